@@ -0,0 +1,336 @@
+package doTorrentDownloader
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+const aria2Port = 6800
+
+type aria2Engine struct {
+	conf      *config
+	sshClient SshClientOp
+}
+
+// aria2Download is a download as returned by aria2's tell* JSON-RPC methods.
+// aria2 encodes all numbers as strings.
+type aria2Download struct {
+	Gid             string   `json:"gid"`
+	Status          string   `json:"status"`
+	TotalLength     string   `json:"totalLength"`
+	CompletedLength string   `json:"completedLength"`
+	UploadLength    string   `json:"uploadLength"`
+	DownloadSpeed   string   `json:"downloadSpeed"`
+	UploadSpeed     string   `json:"uploadSpeed"`
+	NumSeeders      string   `json:"numSeeders"`
+	Connections     string   `json:"connections"`
+	InfoHash        string   `json:"infoHash"`
+	Seeder          string   `json:"seeder"`
+	FollowedBy      []string `json:"followedBy"`
+	Bittorrent      struct {
+		Info struct {
+			Name string `json:"name"`
+		} `json:"info"`
+	} `json:"bittorrent"`
+}
+
+var aria2Keys = []string{
+	"gid", "status", "totalLength", "completedLength", "uploadLength", "downloadSpeed",
+	"infoHash", "seeder", "followedBy", "bittorrent", "uploadSpeed", "numSeeders", "connections",
+}
+
+func newAria2Engine(conf *config, sshClient SshClientOp) *aria2Engine {
+	return &aria2Engine{conf: conf, sshClient: sshClient}
+}
+
+func (engine *aria2Engine) Name() string {
+	return "aria2"
+}
+
+// WebUIPort is 0 as aria2 only has a JSON-RPC interface, which is bound to
+// the droplet's localhost.
+func (engine *aria2Engine) WebUIPort() int {
+	return 0
+}
+
+func (engine *aria2Engine) image() string {
+	return engineImage(engine.conf, "alpine:3")
+}
+
+func (engine *aria2Engine) Setup(attach bool) error {
+	if attach {
+		logInfo("Checking for a running aria2 to attach to...")
+		if engine.isHealthy() {
+			_, err := engine.List()
+			if err == nil {
+				logInfo("Attached to the running aria2.")
+				return nil
+			}
+			return attachError(engine.Name(), err)
+		}
+		logInfo("Setting up aria2 again.")
+	}
+
+	conf := engine.conf
+	// aria2 has no separate directory for incomplete downloads, so it
+	// downloads straight into the completed directory.
+	logInfo(fmt.Sprintf("Creating directory: %s", conf.Qbit.CompletedDir))
+	engine.sshClient.executeCmd(fmt.Sprintf("mkdir -p %s /root/config/aria2 && touch /root/config/aria2/aria2.session", conf.Qbit.CompletedDir))
+
+	// The secret is passed in the environment, it can't be quoted safely
+	// within the quoted command.
+	command := fmt.Sprintf(`sh -c "apk add --no-cache aria2 && exec aria2c \
+		--enable-rpc --rpc-listen-all=true --rpc-listen-port=%d --rpc-secret=\"\$RPC_SECRET\" \
+		--dir=/downloads/completed --continue=true --check-integrity=true \
+		--listen-port=6881 --dht-listen-port=6881 \
+		--input-file=/config/aria2.session --save-session=/config/aria2.session --save-session-interval=30"`,
+		aria2Port)
+	startContainer(engine.sshClient, "aria2", engine.image(), fmt.Sprintf(`\
+		-e RPC_SECRET=%s \
+		-p 127.0.0.1:%d:%d \
+		-p 6881:6881 \
+		-p 6881:6881/udp \
+		-v %s:/downloads/completed \
+		-v /root/config/aria2:/config`,
+		shellQuote(conf.QbittorrentPassword),
+		aria2Port, aria2Port,
+		conf.Qbit.CompletedDir), command)
+
+	logInfo("Waiting for aria2 to initialize...")
+	waitForPort(engine.sshClient, aria2Port)
+	_, err := engine.List()
+	return err
+}
+
+func (engine *aria2Engine) Attach() error {
+	if !engine.isHealthy() {
+		return notRunningError(engine.Name())
+	}
+	if _, err := engine.List(); err != nil {
+		return attachError(engine.Name(), err)
+	}
+	return nil
+}
+
+func (engine *aria2Engine) isHealthy() bool {
+	return containerHealthy(engine.sshClient, "aria2", engine.image(), []string{
+		fmt.Sprintf("%s:/downloads/completed", strings.TrimSuffix(engine.conf.Qbit.CompletedDir, "/")),
+	}, aria2Port)
+}
+
+// rpc calls the aria2 JSON-RPC method with the secret token prepended to
+// the params and decodes its result into result.
+func (engine *aria2Engine) rpc(method string, params []interface{}, result interface{}) error {
+	params = append([]interface{}{"token:" + engine.conf.QbittorrentPassword}, params...)
+	body, err := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      "do-torrent-downloader",
+		"method":  method,
+		"params":  params,
+	})
+	if err != nil {
+		return err
+	}
+
+	cmd := fmt.Sprintf("curl -s -d %s http://localhost:%d/jsonrpc", shellQuote(string(body)), aria2Port)
+	output := engine.sshClient.executeCmd(cmd)
+
+	var response struct {
+		Result json.RawMessage `json:"result"`
+		Error  *struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(output), &response); err != nil {
+		return fmt.Errorf("aria2 RPC %s: %v", method, err)
+	}
+	if response.Error != nil {
+		return fmt.Errorf("aria2 RPC %s failed: %s", method, response.Error.Message)
+	}
+	if result != nil {
+		return json.Unmarshal(response.Result, result)
+	}
+	return nil
+}
+
+func (engine *aria2Engine) Teardown() {
+	logInfo("Stopping and removing aria2 container to stop seeding...")
+	removeContainer(engine.sshClient, "aria2")
+	logInfo("aria2 container removed.")
+}
+
+// downloads returns the active, waiting and stopped downloads. Downloads of
+// magnet metadata that have been followed by the actual torrent are left out.
+func (engine *aria2Engine) downloads() ([]aria2Download, error) {
+	var all []aria2Download
+	for _, call := range []struct {
+		method string
+		params []interface{}
+	}{
+		{"aria2.tellActive", []interface{}{aria2Keys}},
+		{"aria2.tellWaiting", []interface{}{0, 1000, aria2Keys}},
+		{"aria2.tellStopped", []interface{}{0, 1000, aria2Keys}},
+	} {
+		var downloads []aria2Download
+		if err := engine.rpc(call.method, call.params, &downloads); err != nil {
+			return nil, err
+		}
+		for _, d := range downloads {
+			if len(d.FollowedBy) == 0 && d.Status != "removed" {
+				all = append(all, d)
+			}
+		}
+	}
+	return all, nil
+}
+
+func (engine *aria2Engine) List() ([]Torrent, error) {
+	downloads, err := engine.downloads()
+	if err != nil {
+		return nil, err
+	}
+
+	torrents := make([]Torrent, 0, len(downloads))
+	for _, d := range downloads {
+		total, _ := strconv.ParseInt(d.TotalLength, 10, 64)
+		completed, _ := strconv.ParseInt(d.CompletedLength, 10, 64)
+		uploaded, _ := strconv.ParseInt(d.UploadLength, 10, 64)
+		speed, _ := strconv.ParseInt(d.DownloadSpeed, 10, 64)
+		upspeed, _ := strconv.ParseInt(d.UploadSpeed, 10, 64)
+		seeds, _ := strconv.Atoi(d.NumSeeders)
+		connections, _ := strconv.Atoi(d.Connections)
+
+		progress := 0.0
+		if total > 0 {
+			progress = float64(completed) / float64(total)
+		}
+		eta := int64(EtaUnknown)
+		if speed > 0 {
+			eta = (total - completed) / speed
+		}
+		name := d.Bittorrent.Info.Name
+		if name == "" {
+			name = "[METADATA] " + d.InfoHash
+		}
+
+		torrents = append(torrents, Torrent{
+			Hash:       d.InfoHash,
+			Name:       name,
+			Progress:   progress,
+			Dlspeed:    speed,
+			Eta:        eta,
+			State:      aria2State(d, total, completed),
+			Size:       total,
+			Downloaded: completed,
+			Uploaded:   uploaded,
+			Upspeed:    upspeed,
+			Seeds:      seeds,
+			// Connections include the seeds.
+			Peers: connections - seeds,
+		})
+	}
+	return torrents, nil
+}
+
+// aria2State maps aria2's statuses to the normalized states.
+func aria2State(d aria2Download, total int64, completed int64) string {
+	switch d.Status {
+	case "active":
+		if d.Seeder == "true" || (total > 0 && completed == total) {
+			return StateSeeding
+		}
+		if d.Bittorrent.Info.Name == "" {
+			return StateMetadata
+		}
+		if d.DownloadSpeed == "0" {
+			return StateStalled
+		}
+		return StateDownloading
+	case "waiting":
+		return StateQueued
+	case "paused":
+		return StatePaused
+	case "complete":
+		return StateCompleted
+	}
+	return StateError
+}
+
+func (engine *aria2Engine) Status(hash string) (Torrent, error) {
+	return findTorrent(engine, hash)
+}
+
+func (engine *aria2Engine) Add(links []string) error {
+	logInfo("Adding torrents...")
+	for _, link := range links {
+		if err := engine.rpc("aria2.addUri", []interface{}{[]string{link}}, nil); err != nil {
+			return err
+		}
+	}
+	logInfo("Torrents added.")
+	return nil
+}
+
+// gids returns the aria2 download IDs of the torrents with the given hashes.
+func (engine *aria2Engine) gids(hashes []string) (map[string]aria2Download, error) {
+	downloads, err := engine.downloads()
+	if err != nil {
+		return nil, err
+	}
+	gids := map[string]aria2Download{}
+	for _, hash := range hashes {
+		for _, d := range downloads {
+			if strings.EqualFold(d.InfoHash, hash) {
+				gids[d.Gid] = d
+			}
+		}
+	}
+	return gids, nil
+}
+
+func (engine *aria2Engine) Pause(hashes []string) error {
+	return engine.forEachGid(hashes, "aria2.pause")
+}
+
+func (engine *aria2Engine) Resume(hashes []string) error {
+	return engine.forEachGid(hashes, "aria2.unpause")
+}
+
+func (engine *aria2Engine) forEachGid(hashes []string, method string) error {
+	gids, err := engine.gids(hashes)
+	if err != nil {
+		return err
+	}
+	for gid := range gids {
+		if err := engine.rpc(method, []interface{}{gid}, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Remove removes the downloads from aria2. aria2 never deletes downloaded
+// data itself, so the files are removed over SSH when asked to.
+func (engine *aria2Engine) Remove(hashes []string, deleteFiles bool) error {
+	gids, err := engine.gids(hashes)
+	if err != nil {
+		return err
+	}
+	for gid, d := range gids {
+		if d.Status == "active" || d.Status == "waiting" || d.Status == "paused" {
+			if err := engine.rpc("aria2.forceRemove", []interface{}{gid}, nil); err != nil {
+				return err
+			}
+		}
+		engine.rpc("aria2.removeDownloadResult", []interface{}{gid}, nil)
+
+		if deleteFiles && d.Bittorrent.Info.Name != "" {
+			target := path.Join(engine.conf.Qbit.CompletedDir, d.Bittorrent.Info.Name)
+			engine.sshClient.executeCmd(fmt.Sprintf("rm -rf %s %s", shellQuote(target), shellQuote(target+".aria2")))
+		}
+	}
+	return nil
+}
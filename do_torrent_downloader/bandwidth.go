@@ -0,0 +1,309 @@
+package doTorrentDownloader
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// rateUnlimited doesn't limit the transfer.
+	rateUnlimited int64 = 0
+	// ratePaused stops the transfer until a window allows it again.
+	ratePaused int64 = -1
+)
+
+// bandwidthWindow sets the transfer limit between two times of the day,
+// e.g. from 22:00 to 07:00. Windows may span midnight.
+type bandwidthWindow struct {
+	From  string `yaml:"from"`
+	To    string `yaml:"to"`
+	Limit string `yaml:"limit"`
+}
+
+// scheduleWindow is a parsed bandwidthWindow with times in minutes since
+// midnight.
+type scheduleWindow struct {
+	from int
+	to   int
+	rate int64
+}
+
+// bandwidthSchedule gives the transfer rate in bytes per second at a given
+// time: the limit of the first window that contains it, or the default.
+type bandwidthSchedule struct {
+	defaultRate int64
+	windows     []scheduleWindow
+}
+
+var sizePattern = regexp.MustCompile(`^([0-9]+(?:\.[0-9]+)?)\s*([kmgt]?)(?:i?b)?$`)
+
+// parseSize parses a size such as "50MB", "500K" or "1.5 GiB". Sizes are
+// binary.
+func parseSize(size string) (int64, error) {
+	match := sizePattern.FindStringSubmatch(strings.ToLower(strings.TrimSpace(size)))
+	if match == nil {
+		return 0, fmt.Errorf("invalid size %q, use e.g. 50MB or 1.5GB", size)
+	}
+	value, err := strconv.ParseFloat(match[1], 64)
+	if err != nil {
+		return 0, err
+	}
+	multiplier := map[string]float64{"": 1, "k": 1 << 10, "m": 1 << 20, "g": 1 << 30, "t": 1 << 40}[match[2]]
+	return int64(value * multiplier), nil
+}
+
+// parseRate parses a limit such as "2MB/s", "500K" or "1.5 MiB". Empty, "0" and "unlimited" mean no limit, "pause" stops the
+// transfer.
+func parseRate(limit string) (int64, error) {
+	limit = strings.ToLower(strings.TrimSpace(limit))
+	switch limit {
+	case "", "unlimited":
+		return rateUnlimited, nil
+	case "pause", "paused":
+		return ratePaused, nil
+	}
+	rate, err := parseSize(strings.TrimSuffix(limit, "/s"))
+	if err != nil {
+		return 0, fmt.Errorf("invalid bandwidth limit %q, use e.g. 2MB/s, 500KB/s, unlimited or pause", limit)
+	}
+	return rate, nil
+}
+
+// formatRate formats a rate as used in the schedule.
+func formatRate(rate int64) string {
+	switch rate {
+	case rateUnlimited:
+		return "unlimited"
+	case ratePaused:
+		return "paused"
+	}
+	return formatSpeed(float64(rate))
+}
+
+func parseTimeOfDay(value string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, use HH:MM", value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// newBandwidthSchedule parses the limit and windows of the transfer config.
+func newBandwidthSchedule(limit string, windows []bandwidthWindow) (*bandwidthSchedule, error) {
+	defaultRate, err := parseRate(limit)
+	if err != nil {
+		return nil, err
+	}
+	schedule := &bandwidthSchedule{defaultRate: defaultRate}
+	for _, window := range windows {
+		from, err := parseTimeOfDay(window.From)
+		if err != nil {
+			return nil, err
+		}
+		to, err := parseTimeOfDay(window.To)
+		if err != nil {
+			return nil, err
+		}
+		rate, err := parseRate(window.Limit)
+		if err != nil {
+			return nil, err
+		}
+		schedule.windows = append(schedule.windows, scheduleWindow{from: from, to: to, rate: rate})
+	}
+
+	// Make sure the transfer is allowed at some point of the day.
+	midnight := time.Date(2000, 1, 1, 0, 0, 0, 0, time.Local)
+	for minute := 0; minute < 24*60; minute++ {
+		if schedule.RateAt(midnight.Add(time.Duration(minute)*time.Minute)) != ratePaused {
+			return schedule, nil
+		}
+	}
+	return nil, fmt.Errorf("the bandwidth schedule pauses the transfer all day")
+}
+
+// IsLimited tells whether the schedule ever limits or pauses the transfer.
+func (schedule *bandwidthSchedule) IsLimited() bool {
+	if schedule.defaultRate != rateUnlimited {
+		return true
+	}
+	for _, window := range schedule.windows {
+		if window.rate != rateUnlimited {
+			return true
+		}
+	}
+	return false
+}
+
+func (window scheduleWindow) contains(minute int) bool {
+	if window.from == window.to {
+		return true
+	}
+	if window.from < window.to {
+		return minute >= window.from && minute < window.to
+	}
+	return minute >= window.from || minute < window.to
+}
+
+func (schedule *bandwidthSchedule) RateAt(t time.Time) int64 {
+	minute := t.Hour()*60 + t.Minute()
+	for _, window := range schedule.windows {
+		if window.contains(minute) {
+			return window.rate
+		}
+	}
+	return schedule.defaultRate
+}
+
+// NextChange returns the next start or end of a window after t, or the zero
+// time when there are no windows.
+func (schedule *bandwidthSchedule) NextChange(t time.Time) time.Time {
+	var next time.Time
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	for _, window := range schedule.windows {
+		for _, minute := range []int{window.from, window.to} {
+			candidate := midnight.Add(time.Duration(minute) * time.Minute)
+			if !candidate.After(t) {
+				candidate = candidate.AddDate(0, 0, 1)
+			}
+			if next.IsZero() || candidate.Before(next) {
+				next = candidate
+			}
+		}
+	}
+	return next
+}
+
+// NextAllowed returns the time from which the transfer is no longer paused.
+func (schedule *bandwidthSchedule) NextAllowed(t time.Time) time.Time {
+	for schedule.RateAt(t) == ratePaused {
+		t = schedule.NextChange(t)
+	}
+	return t
+}
+
+// bandwidthLimiter applies the schedule to a transfer. It is shared by all
+// of the transfer's workers, so that the limit applies to the total rate.
+type bandwidthLimiter struct {
+	schedule    *bandwidthSchedule
+	hourlyPrice float64
+	// notify prints messages about the limit; it can be replaced to show
+	// them in the progress view.
+	notify func(string)
+
+	mu       sync.Mutex
+	tokens   float64
+	last     time.Time
+	lastRate int64
+}
+
+// newBandwidthLimiter returns nil when the schedule never limits the
+// transfer. hourlyPrice is the droplet's price, used to show what waiting
+// for a window costs.
+func newBandwidthLimiter(conf *config, hourlyPrice float64) (*bandwidthLimiter, error) {
+	schedule, err := newBandwidthSchedule(conf.Transfer.BandwidthLimit, conf.Transfer.Windows)
+	if err != nil {
+		return nil, err
+	}
+	if !schedule.IsLimited() {
+		return nil, nil
+	}
+	return &bandwidthLimiter{
+		schedule:    schedule,
+		hourlyPrice: hourlyPrice,
+		notify:      func(message string) { logInfo(message) },
+		lastRate:    rateUnlimited,
+	}, nil
+}
+
+// Rate returns the current rate in bytes per second, rateUnlimited for no
+// limit. While the schedule pauses the transfer it waits, keeping the
+// droplet, until a window allows it again.
+func (limiter *bandwidthLimiter) Rate() int64 {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	return limiter.rate()
+}
+
+// rate is Rate with mu held.
+func (limiter *bandwidthLimiter) rate() int64 {
+	for {
+		now := time.Now()
+		rate := limiter.schedule.RateAt(now)
+		if rate != ratePaused {
+			if rate != limiter.lastRate {
+				limiter.notify(fmt.Sprintf("Transfer limit: %v%v", formatRate(rate), limiter.until(now)))
+				limiter.lastRate = rate
+			}
+			return rate
+		}
+
+		resume := limiter.schedule.NextAllowed(now)
+		wait := resume.Sub(now)
+		message := fmt.Sprintf("Transfer paused until %v (in %v), keeping the droplet.", resume.Format("15:04"), wait.Round(time.Minute))
+		if limiter.hourlyPrice > 0 {
+			message += fmt.Sprintf(" Waiting costs about $%.2f extra.", wait.Hours()*limiter.hourlyPrice)
+		}
+		limiter.notify(message)
+		limiter.lastRate = ratePaused
+		time.Sleep(wait)
+	}
+}
+
+func (limiter *bandwidthLimiter) until(now time.Time) string {
+	next := limiter.schedule.NextChange(now)
+	if next.IsZero() {
+		return ""
+	}
+	return fmt.Sprintf(" until %v", next.Format("15:04"))
+}
+
+// Take blocks until n more bytes may be transferred.
+func (limiter *bandwidthLimiter) Take(n int) {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	rate := limiter.rate()
+	now := time.Now()
+	if rate == rateUnlimited {
+		limiter.tokens, limiter.last = 0, now
+		return
+	}
+	// Allow bursts of up to a second's worth of data.
+	limiter.tokens += now.Sub(limiter.last).Seconds() * float64(rate)
+	if limiter.tokens > float64(rate) {
+		limiter.tokens = float64(rate)
+	}
+	limiter.tokens -= float64(n)
+	limiter.last = now
+	if limiter.tokens < 0 {
+		time.Sleep(time.Duration(-limiter.tokens / float64(rate) * float64(time.Second)))
+	}
+}
+
+// throttledWriter writes through the limiter.
+type throttledWriter struct {
+	writer  io.Writer
+	limiter *bandwidthLimiter
+}
+
+func (w *throttledWriter) Write(p []byte) (int, error) {
+	w.limiter.Take(len(p))
+	return w.writer.Write(p)
+}
+
+// throttledReader reads through the limiter.
+type throttledReader struct {
+	reader  io.Reader
+	limiter *bandwidthLimiter
+}
+
+func (r *throttledReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.limiter.Take(n)
+	return n, err
+}
@@ -0,0 +1,188 @@
+package doTorrentDownloader
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		limit string
+		want  int64
+	}{
+		{"", rateUnlimited},
+		{"unlimited", rateUnlimited},
+		{"0", rateUnlimited},
+		{"pause", ratePaused},
+		{"Paused", ratePaused},
+		{"500", 500},
+		{"500K", 500 << 10},
+		{"2MB/s", 2 << 20},
+		{"1.5 MiB/s", 3 << 19},
+		{"1g", 1 << 30},
+	}
+	for _, test := range tests {
+		got, err := parseRate(test.limit)
+		if err != nil {
+			t.Errorf("parseRate(%q) failed: %v", test.limit, err)
+		} else if got != test.want {
+			t.Errorf("parseRate(%q) = %d, want %d", test.limit, got, test.want)
+		}
+	}
+	for _, limit := range []string{"fast", "2 MB/h", "-1MB", "1.MB"} {
+		if _, err := parseRate(limit); err == nil {
+			t.Errorf("parseRate(%q) didn't fail", limit)
+		}
+	}
+}
+
+// at returns the time of day on a fixed date.
+func at(clock string) time.Time {
+	t, err := time.ParseInLocation("2006-01-02 15:04", "2024-06-12 "+clock, time.Local)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestBandwidthScheduleRateAt(t *testing.T) {
+	schedule, err := newBandwidthSchedule("1MB", []bandwidthWindow{
+		{From: "22:00", To: "07:00", Limit: "unlimited"},
+		{From: "09:00", To: "17:00", Limit: "pause"},
+		{From: "08:00", To: "12:00", Limit: "100K"}, // shadowed by the window before from 09:00
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		clock string
+		want  int64
+	}{
+		{"21:59", 1 << 20},
+		{"22:00", rateUnlimited},
+		{"23:59", rateUnlimited},
+		{"00:00", rateUnlimited},
+		{"06:59", rateUnlimited},
+		{"07:00", 1 << 20},
+		{"08:30", 100 << 10},
+		{"09:00", ratePaused},
+		{"11:00", ratePaused},
+		{"16:59", ratePaused},
+		{"17:00", 1 << 20},
+	}
+	for _, test := range tests {
+		if got := schedule.RateAt(at(test.clock)); got != test.want {
+			t.Errorf("RateAt(%v) = %d, want %d", test.clock, got, test.want)
+		}
+	}
+
+	if next := schedule.NextChange(at("23:00")); !next.Equal(at("07:00").AddDate(0, 0, 1)) {
+		t.Errorf("NextChange(23:00) = %v, want 07:00 the next day", next)
+	}
+	if next := schedule.NextChange(at("07:00")); !next.Equal(at("08:00")) {
+		t.Errorf("NextChange(07:00) = %v, want 08:00", next)
+	}
+	if next := schedule.NextAllowed(at("10:00")); !next.Equal(at("17:00")) {
+		t.Errorf("NextAllowed(10:00) = %v, want 17:00", next)
+	}
+	if next := schedule.NextAllowed(at("18:00")); !next.Equal(at("18:00")) {
+		t.Errorf("NextAllowed(18:00) = %v, want 18:00", next)
+	}
+}
+
+func TestNewBandwidthScheduleErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		limit   string
+		windows []bandwidthWindow
+	}{
+		{"invalid limit", "fast", nil},
+		{"invalid from", "", []bandwidthWindow{{From: "25:00", To: "07:00"}}},
+		{"invalid to", "", []bandwidthWindow{{From: "22:00", To: "7"}}},
+		{"invalid window limit", "", []bandwidthWindow{{From: "22:00", To: "07:00", Limit: "lots"}}},
+		{"paused all day", "pause", nil},
+		{"paused all day by windows", "pause", []bandwidthWindow{{From: "08:00", To: "08:00", Limit: "pause"}}},
+	}
+	for _, test := range tests {
+		if _, err := newBandwidthSchedule(test.limit, test.windows); err == nil {
+			t.Errorf("%v: newBandwidthSchedule didn't fail", test.name)
+		}
+	}
+}
+
+func TestBandwidthScheduleIsLimited(t *testing.T) {
+	tests := []struct {
+		limit   string
+		windows []bandwidthWindow
+		want    bool
+	}{
+		{"", nil, false},
+		{"unlimited", []bandwidthWindow{{From: "22:00", To: "07:00", Limit: "unlimited"}}, false},
+		{"1MB", nil, true},
+		{"", []bandwidthWindow{{From: "09:00", To: "17:00", Limit: "pause"}}, true},
+	}
+	for _, test := range tests {
+		schedule, err := newBandwidthSchedule(test.limit, test.windows)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := schedule.IsLimited(); got != test.want {
+			t.Errorf("IsLimited() of %q with %v = %v, want %v", test.limit, test.windows, got, test.want)
+		}
+	}
+}
+
+func TestBandwidthLimiterTake(t *testing.T) {
+	const rate = 100 << 10
+	tests := []struct {
+		name  string
+		limit string
+		takes []int
+		// min and max are the time all takes may take.
+		min time.Duration
+		max time.Duration
+	}{
+		{"unlimited", "", []int{1 << 30, 1 << 30}, 0, 100 * time.Millisecond},
+		{"within the burst", "100K", []int{rate / 2, rate / 2}, 0, 100 * time.Millisecond},
+		{"over the burst", "100K", []int{rate, rate / 4}, 200 * time.Millisecond, 700 * time.Millisecond},
+		{"burst doesn't accumulate", "100K", []int{rate, rate, rate / 2}, 1400 * time.Millisecond, 2 * time.Second},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			schedule, err := newBandwidthSchedule(test.limit, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			limiter := &bandwidthLimiter{schedule: schedule, notify: func(string) {}, lastRate: rateUnlimited}
+			start := time.Now()
+			for _, n := range test.takes {
+				limiter.Take(n)
+			}
+			if elapsed := time.Since(start); elapsed < test.min || elapsed > test.max {
+				t.Errorf("took %v, want between %v and %v", elapsed, test.min, test.max)
+			}
+		})
+	}
+}
+
+func TestBandwidthLimiterConcurrentRate(t *testing.T) {
+	schedule, err := newBandwidthSchedule("1GB", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	limiter := &bandwidthLimiter{schedule: schedule, notify: func(string) {}, lastRate: rateUnlimited}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			limiter.Take(1)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		if rate := limiter.Rate(); rate != 1<<30 {
+			t.Errorf("Rate() = %d, want %d", rate, 1<<30)
+		}
+	}
+	<-done
+}
@@ -0,0 +1,91 @@
+package doTorrentDownloader
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+)
+
+// bdecode decodes bencoded data into map[string]interface{}, []interface{},
+// int64 and string values.
+func bdecode(data []byte) (interface{}, error) {
+	value, rest, err := bdecodeValue(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("bencode: %d trailing bytes", len(rest))
+	}
+	return value, nil
+}
+
+func bdecodeValue(data []byte) (interface{}, []byte, error) {
+	if len(data) == 0 {
+		return nil, nil, fmt.Errorf("bencode: unexpected end of data")
+	}
+
+	switch {
+	case data[0] == 'i':
+		end := bytes.IndexByte(data, 'e')
+		if end < 0 {
+			return nil, nil, fmt.Errorf("bencode: unterminated integer")
+		}
+		n, err := strconv.ParseInt(string(data[1:end]), 10, 64)
+		if err != nil {
+			return nil, nil, fmt.Errorf("bencode: invalid integer: %v", err)
+		}
+		return n, data[end+1:], nil
+
+	case data[0] == 'l':
+		list := []interface{}{}
+		rest := data[1:]
+		for len(rest) > 0 && rest[0] != 'e' {
+			value, remaining, err := bdecodeValue(rest)
+			if err != nil {
+				return nil, nil, err
+			}
+			list = append(list, value)
+			rest = remaining
+		}
+		if len(rest) == 0 {
+			return nil, nil, fmt.Errorf("bencode: unterminated list")
+		}
+		return list, rest[1:], nil
+
+	case data[0] == 'd':
+		dict := map[string]interface{}{}
+		rest := data[1:]
+		for len(rest) > 0 && rest[0] != 'e' {
+			key, remaining, err := bdecodeValue(rest)
+			if err != nil {
+				return nil, nil, err
+			}
+			keyString, ok := key.(string)
+			if !ok {
+				return nil, nil, fmt.Errorf("bencode: dictionary key is not a string")
+			}
+			value, remaining, err := bdecodeValue(remaining)
+			if err != nil {
+				return nil, nil, err
+			}
+			dict[keyString] = value
+			rest = remaining
+		}
+		if len(rest) == 0 {
+			return nil, nil, fmt.Errorf("bencode: unterminated dictionary")
+		}
+		return dict, rest[1:], nil
+
+	case data[0] >= '0' && data[0] <= '9':
+		colon := bytes.IndexByte(data, ':')
+		if colon < 0 {
+			return nil, nil, fmt.Errorf("bencode: invalid string length")
+		}
+		length, err := strconv.Atoi(string(data[:colon]))
+		if err != nil || length < 0 || colon+1+length > len(data) {
+			return nil, nil, fmt.Errorf("bencode: invalid string length")
+		}
+		return string(data[colon+1 : colon+1+length]), data[colon+1+length:], nil
+	}
+	return nil, nil, fmt.Errorf("bencode: unexpected byte %q", data[0])
+}
@@ -0,0 +1,57 @@
+package doTorrentDownloader
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBdecode(t *testing.T) {
+	tests := []struct {
+		data string
+		want interface{}
+	}{
+		{"i42e", int64(42)},
+		{"i-7e", int64(-7)},
+		{"i0e", int64(0)},
+		{"4:spam", "spam"},
+		{"0:", ""},
+		{"3:a:b", "a:b"},
+		{"le", []interface{}{}},
+		{"l4:spami1ee", []interface{}{"spam", int64(1)}},
+		{"de", map[string]interface{}{}},
+		{"d3:bar4:spam3:fooi42ee", map[string]interface{}{"bar": "spam", "foo": int64(42)}},
+		{"d4:listl1:a1:bee", map[string]interface{}{"list": []interface{}{"a", "b"}}},
+		{"d1:ad1:bi1eee", map[string]interface{}{"a": map[string]interface{}{"b": int64(1)}}},
+	}
+	for _, test := range tests {
+		got, err := bdecode([]byte(test.data))
+		if err != nil {
+			t.Errorf("bdecode(%q) failed: %v", test.data, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("bdecode(%q) = %#v, want %#v", test.data, got, test.want)
+		}
+	}
+}
+
+func TestBdecodeErrors(t *testing.T) {
+	tests := []string{
+		"",
+		"i42",
+		"iabce",
+		"5:spam",
+		"-1:a",
+		"4spam",
+		"l4:spam",
+		"d3:foo",
+		"di1ei2ee",
+		"i1ei2e",
+		"x",
+	}
+	for _, data := range tests {
+		if got, err := bdecode([]byte(data)); err == nil {
+			t.Errorf("bdecode(%q) = %#v, want an error", data, got)
+		}
+	}
+}
@@ -0,0 +1,327 @@
+package doTorrentDownloader
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/digitalocean/godo"
+)
+
+// cliCommand is a subcommand of the command line.
+type cliCommand struct {
+	name    string
+	summary string
+	run     func(args []string) int
+}
+
+// cliCommands are the subcommands in the order of the help.
+var cliCommands []cliCommand
+
+func init() {
+	cliCommands = []cliCommand{
+		{"run", "Create a droplet (or attach to one with -ip), download, transfer and delete it", sessionCommand(sessionRun, "[flags]")},
+		{"create", "Create a droplet, start the torrent client and add torrents, then exit", sessionCommand(sessionCreate, "[flags]")},
+		{"add", controlCommands["add"], controlCommand("add")},
+		{"status", controlCommands["status"], controlCommand("status")},
+		{"pause", controlCommands["pause"], controlCommand("pause")},
+		{"resume", controlCommands["resume"], controlCommand("resume")},
+		{"remove", controlCommands["remove"], controlCommand("remove")},
+		{"pull", "Transfer the completed downloads of a droplet and delete it", sessionCommand(sessionPull, "[flags]")},
+		{"destroy", "Delete a droplet by IP, ID or name", RunDestroyCommand},
+		{"list", "List the droplets with the configured tag", RunListCommand},
+		{"clean", "Delete all droplets with the configured tag", RunCleanCommand},
+		{"ssh", "Open a shell on a droplet, or run a command on it", RunSshCommand},
+		{"password", "Print the WebUI address and password of a run", RunPasswordCommand},
+		{"verify", "Verify local files against a .torrent file", RunVerifyCommand},
+		{"validate", "Check the configuration against the account", RunValidateCommand},
+		{"init", "Write a configuration from the account", RunInitCommand},
+		{"version", "Print the version", func([]string) int { PrintVersion(); return 0 }},
+	}
+}
+
+// runCLI runs the subcommand of the arguments. Arguments starting with a
+// flag are the command line of older versions, see runLegacy.
+func runCLI(args []string) int {
+	if len(args) == 0 {
+		printUsage()
+		return 2
+	}
+	if strings.HasPrefix(args[0], "-") && args[0] != "-h" && args[0] != "-help" && args[0] != "--help" {
+		return runLegacy(args)
+	}
+	for _, command := range cliCommands {
+		if command.name == args[0] {
+			return command.run(args[1:])
+		}
+	}
+	if args[0] == "help" || args[0] == "-h" || args[0] == "-help" || args[0] == "--help" {
+		printUsage()
+		return 0
+	}
+	fmt.Fprintf(os.Stderr, "Unknown command %q.\n\n", args[0])
+	printUsage()
+	return 2
+}
+
+func printUsage() {
+	fmt.Fprintln(os.Stderr, "Usage: do_torrent_downloader <command> [flags] [args...]\n\nCommands:")
+	for _, command := range cliCommands {
+		fmt.Fprintf(os.Stderr, "  %-9s %s\n", command.name, command.summary)
+	}
+	fmt.Fprintln(os.Stderr, "\nRun `do_torrent_downloader <command> -h` for the flags of a command.")
+}
+
+// commandFlags returns the flag set of a subcommand with its usage.
+func commandFlags(name string, synopsis string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	flags.Usage = func() {
+		summary := ""
+		for _, command := range cliCommands {
+			if command.name == name {
+				summary = command.summary
+			}
+		}
+		fmt.Fprintf(flags.Output(), "Usage: do_torrent_downloader %s %s\n\n%s.\n", name, synopsis, summary)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// configFlags registers the flags selecting the configuration and returns
+// its loader.
+func configFlags(flags *flag.FlagSet) func() (*config, error) {
+	path := flags.String("config", "", "Path of the configuration file (.yml, .yaml or .json)")
+	profile := flags.String("profile", "", "Name of the configuration profile to use")
+	return func() (*config, error) {
+		return LoadConfiguration(*path, *profile)
+	}
+}
+
+func sessionCommand(mode string, synopsis string) func([]string) int {
+	return func(args []string) int {
+		flags := commandFlags(mode, synopsis)
+		sessionFlags(flags, mode)
+		flags.Parse(args)
+		if flags.NArg() > 0 {
+			fmt.Fprintf(os.Stderr, "Unexpected arguments %v, pass magnet links with -m.\n", flags.Args())
+			return 2
+		}
+		return runSession(mode)
+	}
+}
+
+func controlCommand(action string) func([]string) int {
+	return func(args []string) int {
+		return RunControlCommand(action, args)
+	}
+}
+
+// runLegacy runs the flag-only command line of older versions: -v prints
+// the version, -cleanRemote deletes the tagged droplets, -rsyncOnly pulls
+// from a droplet and anything else is a run. Flags of different modes
+// can't be combined.
+func runLegacy(args []string) int {
+	flags := flag.NewFlagSet("do_torrent_downloader", flag.ExitOnError)
+	flags.Usage = printUsage
+	sessionFlags(flags, sessionRun)
+	showVersion := flags.Bool("v", false, "prints current version")
+	cleanRemote := flags.Bool("cleanRemote", false, "Delete all droplets with the configured tag")
+	rsyncOnly := flags.Bool("rsyncOnly", false, "Skip torrent client setup and simply rsync from the droplet")
+	flags.Parse(args)
+
+	switch {
+	case *showVersion:
+		PrintVersion()
+		return 0
+	case *cleanRemote:
+		if len(magnetLinks) > 0 || dropletIp != "" || *rsyncOnly {
+			fmt.Fprintln(os.Stderr, "-cleanRemote can't be combined with -m, -ip or -rsyncOnly. Use `do_torrent_downloader clean`.")
+			return 2
+		}
+		return cleanDroplets(configFile, profileName)
+	case *rsyncOnly:
+		if len(magnetLinks) > 0 {
+			fmt.Fprintln(os.Stderr, "-rsyncOnly can't be combined with -m. Use `do_torrent_downloader pull -ip <ip>`.")
+			return 2
+		}
+		return runSession(sessionPull)
+	}
+	return runSession(sessionRun)
+}
+
+// loadAccount loads the configuration and sets up the DigitalOcean client.
+func loadAccount(load func() (*config, error)) (*config, error) {
+	conf, err := load()
+	if err != nil {
+		return nil, err
+	}
+	InitDoClient(conf.DigitalOceanPat)
+	return conf, nil
+}
+
+// RunListCommand lists the droplets with the configured tag.
+func RunListCommand(args []string) int {
+	flags := commandFlags("list", "[flags]")
+	load := configFlags(flags)
+	flags.Parse(args)
+
+	conf, err := loadAccount(load)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	droplets, err := ListDropletsByTag(conf.DropletTag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error listing droplets by tag: %v\n", err)
+		return 1
+	}
+	if len(droplets) == 0 {
+		fmt.Printf("No droplets found with tag: %s\n", conf.DropletTag)
+		return 0
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "ID\tNAME\tIP\tSTATUS\tSIZE\tREGION\tAGE\tCOST")
+	for _, d := range droplets {
+		ip, _ := d.PublicIPv4()
+		age, cost := "", ""
+		if created, err := time.Parse(time.RFC3339, d.Created); err == nil {
+			age = time.Since(created).Round(time.Minute).String()
+			if d.Size != nil {
+				cost = fmt.Sprintf("$%.2f", d.Size.PriceHourly*time.Since(created).Hours())
+			}
+		}
+		region := ""
+		if d.Region != nil {
+			region = d.Region.Slug
+		}
+		fmt.Fprintf(writer, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", d.ID, d.Name, ip, d.Status, d.SizeSlug, region, age, cost)
+	}
+	writer.Flush()
+	return 0
+}
+
+// findTaggedDroplet finds a droplet with the tag by its IP, ID or name.
+func findTaggedDroplet(tag string, ref string) (*godo.Droplet, error) {
+	droplets, err := ListDropletsByTag(tag)
+	if err != nil {
+		return nil, fmt.Errorf("error listing droplets by tag: %v", err)
+	}
+	var matches []godo.Droplet
+	for _, d := range droplets {
+		ip, _ := d.PublicIPv4()
+		if ip == ref || strconv.Itoa(d.ID) == ref || d.Name == ref {
+			matches = append(matches, d)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("no droplet with tag %v matches %v, see `do_torrent_downloader list`", tag, ref)
+	case 1:
+		return &matches[0], nil
+	}
+	return nil, fmt.Errorf("%d droplets are named %v, pass the IP or ID", len(matches), ref)
+}
+
+// RunDestroyCommand deletes one droplet with the configured tag.
+func RunDestroyCommand(args []string) int {
+	flags := commandFlags("destroy", "[flags] <ip|id|name>")
+	load := configFlags(flags)
+	yes := flags.Bool("y", false, "Don't ask for confirmation")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	conf, err := loadAccount(load)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	d, err := findTaggedDroplet(conf.DropletTag, flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	ip, _ := d.PublicIPv4()
+	if !*yes && !confirmDeletion(fmt.Sprintf("Delete droplet %s (ID: %d, IP: %s)?", d.Name, d.ID, ip)) {
+		fmt.Println("Aborted.")
+		return 1
+	}
+	if _, err := DoClient.Droplets.Delete(context.TODO(), d.ID); err != nil {
+		fmt.Fprintf(os.Stderr, "Error deleting droplet %d: %v\n", d.ID, err)
+		return 1
+	}
+	DeleteJournal(d.ID)
+	fmt.Println("Deleted.")
+	return 0
+}
+
+// RunCleanCommand deletes all droplets with the configured tag.
+func RunCleanCommand(args []string) int {
+	flags := commandFlags("clean", "[flags]")
+	path := flags.String("config", "", "Path of the configuration file (.yml, .yaml or .json)")
+	profile := flags.String("profile", "", "Name of the configuration profile to use")
+	flags.Parse(args)
+	return cleanDroplets(*path, *profile)
+}
+
+func cleanDroplets(path string, profile string) int {
+	conf, err := LoadConfiguration(path, profile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	InitDoClient(conf.DigitalOceanPat)
+	fmt.Printf("Cleaning up droplets with tag: %s\n", conf.DropletTag)
+	DeleteDropletsByTag(conf.DropletTag)
+	return 0
+}
+
+// RunSshCommand runs ssh to the droplet of -ip, or of the latest run, with
+// the configured private key.
+func RunSshCommand(args []string) int {
+	flags := commandFlags("ssh", "[flags] [command...]")
+	load := configFlags(flags)
+	ip := flags.String("ip", "", "Public IP of the droplet (defaults to the one of the latest run)")
+	flags.Parse(args)
+
+	conf, err := load()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if *ip == "" {
+		latest, err := LatestJournal()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Pass the droplet with -ip: %v\n", err)
+			return 1
+		}
+		*ip = latest.DropletIP
+	}
+
+	sshArgs := []string{"-o", "StrictHostKeyChecking=no"}
+	if conf.SshPrivateKeyPath != "" {
+		sshArgs = append(sshArgs, "-i", conf.SshPrivateKeyPath)
+	}
+	sshArgs = append(sshArgs, "root@"+*ip)
+	sshArgs = append(sshArgs, flags.Args()...)
+	cmd := exec.Command("ssh", sshArgs...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return exitErr.ExitCode()
+		}
+		fmt.Fprintf(os.Stderr, "Error running ssh: %v\n", err)
+		return 1
+	}
+	return 0
+}
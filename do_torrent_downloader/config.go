@@ -1,12 +1,16 @@
 package doTorrentDownloader
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"gopkg.in/yaml.v2"
 	"io/ioutil"
 	"os"
-	"os/user"
 	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
 )
 
 type config struct {
@@ -18,68 +22,370 @@ type config struct {
 	SshPrivateKeyPath   string `yaml:"ssh_private_key_path"`
 	DownloadDir         string `yaml:"download_dir"`
 	DigitalOceanPat     string `yaml:"digital_ocean_pat"`
+	Engine              string `yaml:"engine"`
+	EngineImage         string `yaml:"engine_image"`
 	QbittorrentVersion  string `yaml:"qbittorrent_version"`
 	QbittorrentPassword string `yaml:"qbittorrent_password"`
 	DropletDownloadDir  string `yaml:"droplet_download_dir"`
 	DropletTag          string `yaml:"droplet_tag"`
+	ControlSocket       string `yaml:"control_socket"`
 	Qbit                struct {
-		IncomingDir  string `yaml:"incoming_dir"`
-		CompletedDir string `yaml:"completed_dir"`
+		IncomingDir  string                 `yaml:"incoming_dir"`
+		CompletedDir string                 `yaml:"completed_dir"`
+		Preferences  map[string]interface{} `yaml:"preferences"`
 	}
+	Transfer struct {
+		Engine       string `yaml:"engine"`
+		Parallel     int    `yaml:"parallel"`
+		SkipVerify   bool   `yaml:"skip_verify"`
+		VerifyPieces bool   `yaml:"verify_pieces"`
+		// Bandwidth limit outside of the windows, e.g. 2MB/s.
+		BandwidthLimit string            `yaml:"bandwidth_limit"`
+		Windows        []bandwidthWindow `yaml:"windows"`
+		// Packing of many small files into a tar stream: auto, always or never.
+		Pack            string `yaml:"pack"`
+		PackThreshold   int    `yaml:"pack_threshold"`
+		PackCompression string `yaml:"pack_compression"`
+		// Rules of which files are transferred and where to.
+		Include      []string          `yaml:"include"`
+		Exclude      []string          `yaml:"exclude"`
+		MinSize      string            `yaml:"min_size"`
+		Destination  string            `yaml:"destination"`
+		Destinations []destinationRule `yaml:"destinations"`
+		// Free space kept on the local disk, e.g. 1GB, and what to do when the
+		// transfer doesn't fit: abort (default), warn or off.
+		DiskReserve string `yaml:"disk_reserve"`
+		DiskCheck   string `yaml:"disk_check"`
+	} `yaml:"transfer"`
+	// Extraction of RAR, ZIP and 7z sets on the droplet, see extract.go.
+	Extract struct {
+		Enabled bool `yaml:"enabled"`
+		// Image with 7z, alpine with 7zip installed by default.
+		Image string `yaml:"image"`
+		// What is transferred: extracted (default) or both.
+		Transfer string `yaml:"transfer"`
+	} `yaml:"extract"`
+	// How long completed torrents are seeded before the transfer, see
+	// seeding.go. They aren't seeded by default.
+	Seeding struct {
+		Ratio float64 `yaml:"ratio"`
+		Time  string  `yaml:"time"`
+	} `yaml:"seeding"`
+	// The log printed and written to a file per run, see log.go.
+	Log struct {
+		// Level printed: debug, info (default), warn or error. The file
+		// gets all levels.
+		Level string `yaml:"level"`
+		// Directory of the run logs, logs in the state directory by default.
+		Dir string `yaml:"dir"`
+		// Number of run logs kept, 0 keeps all.
+		Keep int `yaml:"keep"`
+	} `yaml:"log"`
+	// Profiles are named sets of overrides of the fields above, selected
+	// with -profile. A profile may inherit from another one.
+	Profiles map[string]map[string]interface{} `yaml:"profiles"`
+	// Hooks are shell commands run at points of a run, see hooks.go.
+	Hooks struct {
+		Timeout              string   `yaml:"timeout"`
+		OnTorrentComplete    []string `yaml:"on_torrent_complete"`
+		OnTorrentTransferred []string `yaml:"on_torrent_transferred"`
+		OnRunFinished        []string `yaml:"on_run_finished"`
+	} `yaml:"hooks"`
+	// Notifications of the events of a run, see notify.go.
+	Notify struct {
+		// Budget in USD, budget_exceeded is notified when the droplet costs
+		// more. 0 is no budget.
+		Budget   float64               `yaml:"budget"`
+		Channels []notifyChannelConfig `yaml:"channels"`
+	} `yaml:"notify"`
 }
 
-func LoadConfiguration(filename string) *config {
-	file := findConfigFile(filename)
-	return readFile(file)
+// notifyChannelConfig is a channel of notify.channels.
+type notifyChannelConfig struct {
+	// Type is webhook, ntfy or email.
+	Type string `yaml:"type"`
+	// Events notified, all by default.
+	Events []string `yaml:"events"`
+	// Templates of the title and message by event.
+	Templates map[string]notifyTemplate `yaml:"templates"`
+	// URL of the webhook or the ntfy topic, and the bearer token if any.
+	URL     string            `yaml:"url"`
+	Token   string            `yaml:"token"`
+	Headers map[string]string `yaml:"headers"`
+	// SMTP server as host:port and the email addresses.
+	Host     string   `yaml:"host"`
+	Username string   `yaml:"username"`
+	Password string   `yaml:"password"`
+	From     string   `yaml:"from"`
+	To       []string `yaml:"to"`
 }
 
-func findConfigFile(filename string) string {
-	var dirs []string
+// configName is the base name of the configuration file, with a .yml, .yaml
+// or .json extension.
+const configName = "do-torrent-downloader"
+
+// envPrefix prefixes the environment variables overriding config fields,
+// e.g. DOTD_DIGITAL_OCEAN_PAT or DOTD_TRANSFER_BANDWIDTH_LIMIT.
+const envPrefix = "DOTD_"
 
-	// Look in current working directory for the file.
-	wDir, _ := os.Getwd()
-	// Check user's home directory
-	usr, _ := user.Current()
+// defaultConfig is what is used for the fields neither the file nor the
+// environment set.
+func defaultConfig() *config {
+	conf := &config{
+		Size:               "s-1vcpu-1gb",
+		ImageSlug:          "docker-20-04",
+		DropletName:        "torrent-downloader",
+		DropletTag:         "do-torrent-downloader",
+		QbittorrentVersion: "latest",
+	}
+	conf.Qbit.IncomingDir = "/root/incoming-torrents"
+	conf.Qbit.CompletedDir = "/root/Downloads"
+	conf.Log.Keep = defaultLogKeep
+	if home, err := os.UserHomeDir(); err == nil {
+		conf.SshPrivateKeyPath = filepath.Join(home, ".ssh", "id_rsa")
+	}
+	return conf
+}
 
-	dirs = append(dirs, wDir, usr.HomeDir)
+// LoadConfiguration layers the configuration: the defaults, then the file,
+// then the profile, if any, then DOTD_* environment variables, and resolves the secrets of the
+// result, see resolveSecret. Command line flags are applied on top
+// by the caller. The file is the one given, or DOTD_CONFIG, or the first
+// found by findConfigFile; without any, the defaults and the environment
+// are used. The profile may also be given by DOTD_PROFILE.
+func LoadConfiguration(filename string, profile string) (*config, error) {
+	conf := defaultConfig()
 
-	for _, dir := range dirs {
-		filePath := filepath.Join(dir, filename)
-		if _, err := os.Stat(filePath); err == nil {
-			fmt.Printf("Using the configuration: %v\n", filePath)
-			return filePath
+	if filename == "" {
+		filename = os.Getenv(envPrefix + "CONFIG")
+	}
+	if filename == "" {
+		filename = findConfigFile()
+	}
+	if filename != "" {
+		logInfo("Using the configuration", "file", filename)
+		if err := readFile(filename, conf); err != nil {
+			return nil, err
 		}
+	} else {
+		logInfo("No configuration file found, using the defaults and the environment.")
 	}
+	if profile == "" {
+		profile = os.Getenv(envPrefix + "PROFILE")
+	}
+	if profile != "" {
+		logInfo("Using the profile", "name", profile)
+		if err := applyProfile(conf, profile); err != nil {
+			return nil, err
+		}
+	}
+	conf.Profiles = nil
 
-	panic("Didn't find the config file.")
+	overridden, err := applyEnv(conf, os.Environ())
+	if err != nil {
+		return nil, err
+	}
+	if len(overridden) > 0 {
+		logInfo("Overridden by the environment", "variables", strings.Join(overridden, ","))
+	}
+	if err := resolveSecrets(conf); err != nil {
+		return nil, err
+	}
+	return conf, nil
 }
 
-// readFile will read the config file
-// and return the created config.
-func readFile(filename string) *config {
+// applyProfile overrides the fields of conf with those of the profile,
+// after those of the profiles it inherits from.
+func applyProfile(conf *config, name string) error {
+	var chain []map[string]interface{}
+	seen := map[string]bool{}
+	for name != "" {
+		if seen[name] {
+			return fmt.Errorf("profile %v inherits from itself", name)
+		}
+		seen[name] = true
+		profile, ok := conf.Profiles[name]
+		if !ok {
+			var names []string
+			for known := range conf.Profiles {
+				names = append(names, known)
+			}
+			sort.Strings(names)
+			if len(names) == 0 {
+				return fmt.Errorf("unknown profile %v, the configuration has no profiles", name)
+			}
+			return fmt.Errorf("unknown profile %v, use one of %v", name, strings.Join(names, ", "))
+		}
+		chain = append([]map[string]interface{}{profile}, chain...)
+		name, _ = profile["inherits"].(string)
+	}
+
+	for _, profile := range chain {
+		overrides := map[string]interface{}{}
+		for key, value := range profile {
+			if key != "inherits" && key != "profiles" {
+				overrides[key] = value
+			}
+		}
+		data, err := yaml.Marshal(overrides)
+		if err != nil {
+			return err
+		}
+		if err := yaml.Unmarshal(data, conf); err != nil {
+			return fmt.Errorf("error applying the profile: %v", err)
+		}
+	}
+	return nil
+}
+
+// configDirs are the directories searched for the configuration file, in
+// order: the working directory, the XDG config directory and the home
+// directory.
+func configDirs() []string {
+	var dirs []string
+	if wDir, err := os.Getwd(); err == nil {
+		dirs = append(dirs, wDir)
+	}
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		dirs = append(dirs, filepath.Join(dir, configName))
+	} else if home, err := os.UserHomeDir(); err == nil {
+		dirs = append(dirs, filepath.Join(home, ".config", configName))
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		dirs = append(dirs, home)
+	}
+	return dirs
+}
+
+// findConfigFile returns the first configuration file of configDirs, or ""
+// when there is none.
+func findConfigFile() string {
+	for _, dir := range configDirs() {
+		for _, ext := range []string{".yml", ".yaml", ".json"} {
+			filePath := filepath.Join(dir, configName+ext)
+			if _, err := os.Stat(filePath); err == nil {
+				return filePath
+			}
+		}
+	}
+	return ""
+}
+
+// readFile reads the config file over conf.
+func readFile(filename string, conf *config) error {
 	data, err := ioutil.ReadFile(filename)
 	if err != nil {
-		panic(fmt.Sprintf("Error reading file %v", err))
+		return fmt.Errorf("error reading the configuration: %v", err)
+	}
+	if err := unmarshal(data, filepath.Ext(filename), conf); err != nil {
+		return fmt.Errorf("error reading %v: %v", filename, err)
 	}
+	return nil
+}
 
-	ext := filepath.Ext(filename)
-	return unmarshal(data, ext)
+// unmarshal converts YAML or JSON into the config. JSON uses the same keys
+// as YAML, so it is decoded generically and then read as YAML.
+func unmarshal(data []byte, ext string, conf *config) error {
+	switch ext {
+	case ".yml", ".yaml":
+		return yaml.Unmarshal(data, conf)
+	case ".json":
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		var value interface{}
+		if err := decoder.Decode(&value); err != nil {
+			return err
+		}
+		converted, err := yaml.Marshal(jsonNumbers(value))
+		if err != nil {
+			return err
+		}
+		return yaml.Unmarshal(converted, conf)
+	}
+	return fmt.Errorf("unrecognized file extension %q, use .yml, .yaml or .json", ext)
 }
 
-// unmarshal converts YAML into a config object.
-func unmarshal(data []byte, ext string) *config {
-	var config *config
-	var err error
-	if ext == ".json" {
-		fmt.Println("Not supported yet!")
-		// err = json.Unmarshal(data, &config)
-	} else if ext == ".yml" || ext == ".yaml" {
-		err = yaml.Unmarshal(data, &config)
-	} else {
-		panic("Unrecognized file extension")
+// jsonNumbers replaces the json.Numbers of a decoded value with ints or
+// floats, which YAML reads back as numbers.
+func jsonNumbers(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = jsonNumbers(item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = jsonNumbers(item)
+		}
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		f, _ := v.Float64()
+		return f
 	}
-	if err != nil {
-		panic(fmt.Sprintf("Error during Unmarshal: %v", err))
+	return value
+}
+
+// applyEnv sets the fields of conf that have a DOTD_* variable in env. The
+// names are the YAML keys of the field's path, upper cased and joined by
+// underscores. Strings are taken as is, lists may also be comma separated
+// and other values are read as YAML. It returns the variables applied.
+func applyEnv(conf *config, env []string) ([]string, error) {
+	values := map[string]string{}
+	for _, variable := range env {
+		if parts := strings.SplitN(variable, "=", 2); len(parts) == 2 && strings.HasPrefix(parts[0], envPrefix) {
+			values[parts[0]] = parts[1]
+		}
+	}
+	var applied []string
+	err := applyEnvFields(reflect.ValueOf(conf).Elem(), strings.TrimSuffix(envPrefix, "_"), values, &applied)
+	sort.Strings(applied)
+	return applied, err
+}
+
+func applyEnvFields(value reflect.Value, prefix string, values map[string]string, applied *[]string) error {
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		key := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if key == "-" {
+			continue
+		}
+		if key == "" {
+			key = strings.ToLower(field.Name)
+		}
+		name := prefix + "_" + strings.ToUpper(key)
+		target := value.Field(i)
+
+		if target.Kind() == reflect.Struct {
+			if err := applyEnvFields(target, name, values, applied); err != nil {
+				return err
+			}
+			continue
+		}
+		raw, ok := values[name]
+		if !ok {
+			continue
+		}
+		switch {
+		case target.Kind() == reflect.String:
+			target.SetString(raw)
+		case target.Type() == reflect.TypeOf([]string{}) && !strings.HasPrefix(strings.TrimSpace(raw), "["):
+			var items []string
+			for _, item := range strings.Split(raw, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					items = append(items, item)
+				}
+			}
+			target.Set(reflect.ValueOf(items))
+		default:
+			parsed := reflect.New(target.Type())
+			if err := yaml.UnmarshalStrict([]byte(raw), parsed.Interface()); err != nil {
+				return fmt.Errorf("invalid %v: %v", name, err)
+			}
+			target.Set(parsed.Elem())
+		}
+		*applied = append(*applied, name)
 	}
-	return config
+	return nil
 }
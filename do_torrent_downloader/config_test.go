@@ -0,0 +1,305 @@
+package doTorrentDownloader
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeConfig writes a configuration file with the given name to a
+// temporary directory and returns its path.
+func writeConfig(t *testing.T, name string, content string) string {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(filename, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestLoadConfigurationLayers(t *testing.T) {
+	file := `
+size: s-2vcpu-2gb
+region: ams3
+download_dir: /file
+transfer:
+  parallel: 4
+  bandwidth_limit: 1MB
+profiles:
+  fast:
+    region: fra1
+    download_dir: /profile
+    transfer:
+      parallel: 8
+`
+	tests := []struct {
+		name    string
+		profile string
+		env     map[string]string
+		check   func(conf *config) []interface{}
+		want    []interface{}
+	}{
+		{
+			name: "defaults and file",
+			check: func(conf *config) []interface{} {
+				return []interface{}{conf.Size, conf.Region, conf.DownloadDir, conf.DropletName, conf.Transfer.Parallel, conf.Transfer.BandwidthLimit}
+			},
+			want: []interface{}{"s-2vcpu-2gb", "ams3", "/file", "torrent-downloader", 4, "1MB"},
+		},
+		{
+			name:    "profile over file",
+			profile: "fast",
+			check: func(conf *config) []interface{} {
+				return []interface{}{conf.Size, conf.Region, conf.DownloadDir, conf.Transfer.Parallel, conf.Transfer.BandwidthLimit, conf.Profiles == nil}
+			},
+			want: []interface{}{"s-2vcpu-2gb", "fra1", "/profile", 8, "1MB", true},
+		},
+		{
+			name:    "environment over profile",
+			profile: "fast",
+			env:     map[string]string{"DOTD_DOWNLOAD_DIR": "/env", "DOTD_TRANSFER_PARALLEL": "2", "DOTD_DROPLET_NAME": "env-droplet"},
+			check: func(conf *config) []interface{} {
+				return []interface{}{conf.Region, conf.DownloadDir, conf.Transfer.Parallel, conf.DropletName}
+			},
+			want: []interface{}{"fra1", "/env", 2, "env-droplet"},
+		},
+		{
+			name: "profile from the environment",
+			env:  map[string]string{"DOTD_PROFILE": "fast"},
+			check: func(conf *config) []interface{} {
+				return []interface{}{conf.Region, conf.Transfer.Parallel}
+			},
+			want: []interface{}{"fra1", 8},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for name, value := range test.env {
+				t.Setenv(name, value)
+			}
+			conf, err := LoadConfiguration(writeConfig(t, "config.yml", file), test.profile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := test.check(conf); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigurationFromEnvironment(t *testing.T) {
+	t.Setenv("DOTD_CONFIG", writeConfig(t, "config.yaml", "region: sgp1\n"))
+	conf, err := LoadConfiguration("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.Region != "sgp1" {
+		t.Errorf("region is %q, want the one of DOTD_CONFIG", conf.Region)
+	}
+
+	if _, err := LoadConfiguration(writeConfig(t, "config.toml", ""), ""); err == nil {
+		t.Error("LoadConfiguration read a .toml file")
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	tests := []struct {
+		name  string
+		env   []string
+		check func(conf *config) interface{}
+		want  interface{}
+	}{
+		{"string", []string{"DOTD_REGION=nyc3"}, func(conf *config) interface{} { return conf.Region }, "nyc3"},
+		{"string kept as is", []string{"DOTD_QBITTORRENT_PASSWORD=123"}, func(conf *config) interface{} { return conf.QbittorrentPassword }, "123"},
+		{"nested struct", []string{"DOTD_QBIT_COMPLETED_DIR=/done"}, func(conf *config) interface{} { return conf.Qbit.CompletedDir }, "/done"},
+		{"int", []string{"DOTD_TRANSFER_PARALLEL=3"}, func(conf *config) interface{} { return conf.Transfer.Parallel }, 3},
+		{"bool", []string{"DOTD_TRANSFER_SKIP_VERIFY=true"}, func(conf *config) interface{} { return conf.Transfer.SkipVerify }, true},
+		{"float", []string{"DOTD_SEEDING_RATIO=1.5"}, func(conf *config) interface{} { return conf.Seeding.Ratio }, 1.5},
+		{"comma separated list", []string{"DOTD_TRANSFER_INCLUDE=*.mkv, *.srt,"}, func(conf *config) interface{} { return conf.Transfer.Include }, []string{"*.mkv", "*.srt"}},
+		{"YAML list", []string{`DOTD_TRANSFER_EXCLUDE=["a,b", c]`}, func(conf *config) interface{} { return conf.Transfer.Exclude }, []string{"a,b", "c"}},
+		{
+			"list of structs", []string{`DOTD_TRANSFER_WINDOWS=[{from: "22:00", to: "07:00", limit: unlimited}]`},
+			func(conf *config) interface{} { return conf.Transfer.Windows },
+			[]bandwidthWindow{{From: "22:00", To: "07:00", Limit: "unlimited"}},
+		},
+		{
+			"map", []string{"DOTD_QBIT_PREFERENCES={max_connec: 200}"},
+			func(conf *config) interface{} { return conf.Qbit.Preferences },
+			map[string]interface{}{"max_connec": 200},
+		},
+		{"other prefixes ignored", []string{"REGION=nyc3", "DOTDREGION=nyc3"}, func(conf *config) interface{} { return conf.Region }, ""},
+	}
+	for _, test := range tests {
+		conf := &config{}
+		if _, err := applyEnv(conf, test.env); err != nil {
+			t.Errorf("%v: applyEnv failed: %v", test.name, err)
+			continue
+		}
+		if got := test.check(conf); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: got %#v, want %#v", test.name, got, test.want)
+		}
+	}
+
+	applied, err := applyEnv(&config{}, []string{"DOTD_TRANSFER_PARALLEL=3", "DOTD_REGION=nyc3", "DOTD_UNKNOWN=1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"DOTD_REGION", "DOTD_TRANSFER_PARALLEL"}; !reflect.DeepEqual(applied, want) {
+		t.Errorf("applied %v, want %v", applied, want)
+	}
+
+	for _, variable := range []string{"DOTD_TRANSFER_PARALLEL=many", "DOTD_TRANSFER_SKIP_VERIFY=maybe", "DOTD_TRANSFER_WINDOWS=[{start: 1}]"} {
+		if _, err := applyEnv(&config{}, []string{variable}); err == nil {
+			t.Errorf("applyEnv(%v) didn't fail", variable)
+		}
+	}
+}
+
+// JSON numbers must not arrive as float64, which would lose large ints and
+// turn ints into floats in untyped fields like the preferences.
+func TestUnmarshalJSON(t *testing.T) {
+	data := `{
+		"region": "lon1",
+		"transfer": {"parallel": 4, "pack_threshold": 9007199254740993, "windows": [{"from": "22:00", "to": "07:00"}]},
+		"seeding": {"ratio": 2, "time": "1h"},
+		"notify": {"budget": 0.5},
+		"qbit": {"preferences": {"max_connec": 200, "max_ratio": 1.5}}
+	}`
+	conf := defaultConfig()
+	if err := unmarshal([]byte(data), ".json", conf); err != nil {
+		t.Fatal(err)
+	}
+	got := []interface{}{
+		conf.Region, conf.Size, conf.Transfer.Parallel, conf.Transfer.PackThreshold, conf.Transfer.Windows,
+		conf.Seeding.Ratio, conf.Notify.Budget, conf.Qbit.Preferences,
+	}
+	want := []interface{}{
+		"lon1", "s-1vcpu-1gb", 4, 9007199254740993, []bandwidthWindow{{From: "22:00", To: "07:00"}},
+		2.0, 0.5, map[string]interface{}{"max_connec": 200, "max_ratio": 1.5},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v\nwant %#v", got, want)
+	}
+}
+
+func TestApplyProfile(t *testing.T) {
+	file := `
+region: ams3
+size: s-1vcpu-1gb
+qbit:
+  preferences:
+    max_connec: 100
+    dht: true
+transfer:
+  include: ["*.iso"]
+  destination: "{name}"
+seeding:
+  ratio: 1
+profiles:
+  base:
+    size: s-4vcpu-8gb
+    qbit:
+      preferences:
+        max_connec: 500
+  big:
+    inherits: base
+    region: fra1
+    transfer:
+      include: ["*.mkv", "*.srt"]
+      min_size: 1MB
+    seeding:
+      time: 2h
+  bigger:
+    inherits: big
+    size: s-8vcpu-16gb
+    seeding:
+      ratio: 0
+  loop-a:
+    inherits: loop-b
+  loop-b:
+    inherits: loop-a
+  self:
+    inherits: self
+  orphan:
+    inherits: missing
+`
+	base := defaultConfig()
+	if err := unmarshal([]byte(file), ".yml", base); err != nil {
+		t.Fatal(err)
+	}
+	load := func(t *testing.T, profile string) (*config, error) {
+		t.Helper()
+		conf := defaultConfig()
+		if err := unmarshal([]byte(file), ".yml", conf); err != nil {
+			t.Fatal(err)
+		}
+		return conf, applyProfile(conf, profile)
+	}
+
+	tests := []struct {
+		profile     string
+		size        string
+		region      string
+		preferences map[string]interface{}
+		include     []string
+		minSize     string
+		seeding     string
+	}{
+		{"base", "s-4vcpu-8gb", "ams3", map[string]interface{}{"max_connec": 500, "dht": true}, []string{"*.iso"}, "", "until ratio 1.0 or 24h"},
+		{"big", "s-4vcpu-8gb", "fra1", map[string]interface{}{"max_connec": 500, "dht": true}, []string{"*.mkv", "*.srt"}, "1MB", "until ratio 1.0 or 2h"},
+		{"bigger", "s-8vcpu-16gb", "fra1", map[string]interface{}{"max_connec": 500, "dht": true}, []string{"*.mkv", "*.srt"}, "1MB", "for 2h"},
+	}
+	for _, test := range tests {
+		t.Run(test.profile, func(t *testing.T) {
+			conf, err := load(t, test.profile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if conf.Size != test.size || conf.Region != test.region {
+				t.Errorf("size %v in %v, want %v in %v", conf.Size, conf.Region, test.size, test.region)
+			}
+			if !reflect.DeepEqual(conf.Qbit.Preferences, test.preferences) {
+				t.Errorf("preferences %v, want %v", conf.Qbit.Preferences, test.preferences)
+			}
+			if !reflect.DeepEqual(conf.Transfer.Include, test.include) || conf.Transfer.MinSize != test.minSize || conf.Transfer.Destination != "{name}" {
+				t.Errorf("transfer %+v, want include %v and min size %q", conf.Transfer, test.include, test.minSize)
+			}
+			if _, err := newTransferRules(conf); err != nil {
+				t.Errorf("the transfer rules of the profile are invalid: %v", err)
+			}
+			policy, err := newSeedingPolicy(conf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if policy.String() != test.seeding {
+				t.Errorf("seeding %v, want %v", policy, test.seeding)
+			}
+		})
+	}
+
+	if !reflect.DeepEqual(base.Qbit.Preferences, map[string]interface{}{"max_connec": 100, "dht": true}) {
+		t.Errorf("the profiles changed the preferences of another config: %v", base.Qbit.Preferences)
+	}
+
+	errorTests := []struct {
+		profile string
+		want    string
+	}{
+		{"small", "unknown profile small, use one of base, big, bigger, loop-a, loop-b, orphan, self"},
+		{"orphan", "unknown profile missing"},
+		{"loop-a", "profile loop-a inherits from itself"},
+		{"self", "profile self inherits from itself"},
+	}
+	for _, test := range errorTests {
+		if _, err := load(t, test.profile); err == nil || !strings.HasPrefix(err.Error(), test.want) {
+			t.Errorf("applying %v failed with %v, want %q", test.profile, err, test.want)
+		}
+	}
+
+	if err := applyProfile(defaultConfig(), "big"); err == nil || err.Error() != "unknown profile big, the configuration has no profiles" {
+		t.Errorf("applying a profile without profiles failed with %v", err)
+	}
+}
@@ -0,0 +1,310 @@
+package doTorrentDownloader
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// controlCommands are the client subcommands that talk to a running
+// session over its control socket. Without a session, e.g. after create,
+// they drive the torrent engine on the droplet directly.
+var controlCommands = map[string]string{
+	"add":    "Add magnet links to the running session or droplet",
+	"pause":  "Pause torrents by hash prefix, name or \"all\"",
+	"resume": "Resume torrents by hash prefix, name or \"all\"",
+	"remove": "Remove torrents (and their data) by hash prefix, name or \"all\"",
+	"status": "Print the torrents of the running session or droplet",
+}
+
+var pastTense = map[string]string{
+	"pause":  "Paused",
+	"resume": "Resumed",
+	"remove": "Removed",
+}
+
+type controlRequest struct {
+	Action string   `json:"action"`
+	Args   []string `json:"args"`
+}
+
+type controlResponse struct {
+	Ok       bool      `json:"ok"`
+	Error    string    `json:"error,omitempty"`
+	Message  string    `json:"message,omitempty"`
+	Torrents []Torrent `json:"torrents,omitempty"`
+}
+
+type controlServer struct {
+	path     string
+	listener net.Listener
+	engine   TorrentEngine
+	mu       sync.Mutex
+}
+
+// defaultControlSocket is where a session listens when no socket path is
+// given in the config or on the command line: the user's runtime directory,
+// or the state directory when there is none.
+func defaultControlSocket() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "do-torrent-downloader.sock")
+	}
+	return filepath.Join(stateDir(), "control.sock")
+}
+
+// StartControlServer listens on the Unix socket at path and serves control
+// requests against the torrent engine. Only the user may connect, as the
+// requests can delete downloads.
+func StartControlServer(path string, engine TorrentEngine) (*controlServer, error) {
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("another session is already listening on %s", path)
+	}
+	// Remove a stale socket left behind by a session that didn't exit cleanly.
+	os.Remove(path)
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	server := &controlServer{
+		path:     path,
+		listener: listener,
+		engine:   engine,
+	}
+	go server.serve()
+	return server, nil
+}
+
+func (s *controlServer) Close() {
+	s.listener.Close()
+	os.Remove(s.path)
+}
+
+func (s *controlServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *controlServer) handle(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Minute))
+
+	var request controlRequest
+	if err := json.NewDecoder(conn).Decode(&request); err != nil {
+		json.NewEncoder(conn).Encode(controlResponse{Error: fmt.Sprintf("invalid request: %v", err)})
+		return
+	}
+
+	// Requests are serialized so that concurrent clients don't race on the
+	// same torrents.
+	s.mu.Lock()
+	response := dispatchControl(s.engine, request)
+	s.mu.Unlock()
+
+	json.NewEncoder(conn).Encode(response)
+}
+
+// dispatchControl carries out the request against the engine.
+func dispatchControl(engine TorrentEngine, request controlRequest) controlResponse {
+	switch request.Action {
+	case "add":
+		if len(request.Args) == 0 {
+			return controlResponse{Error: "no magnet links given"}
+		}
+		if err := engine.Add(request.Args); err != nil {
+			return controlResponse{Error: err.Error()}
+		}
+		return controlResponse{Ok: true, Message: fmt.Sprintf("Added %d torrent(s).", len(request.Args))}
+	case "status":
+		torrents, err := engine.List()
+		if err != nil {
+			return controlResponse{Error: err.Error()}
+		}
+		return controlResponse{Ok: true, Torrents: torrents}
+	case "pause", "resume", "remove":
+		hashes, err := resolveTorrents(engine, request.Args)
+		if err != nil {
+			return controlResponse{Error: err.Error()}
+		}
+		switch request.Action {
+		case "pause":
+			err = engine.Pause(hashes)
+		case "resume":
+			err = engine.Resume(hashes)
+		case "remove":
+			err = engine.Remove(hashes, true)
+		}
+		if err != nil {
+			return controlResponse{Error: err.Error()}
+		}
+		return controlResponse{Ok: true, Message: fmt.Sprintf("%s %d torrent(s).", pastTense[request.Action], len(hashes))}
+	}
+	return controlResponse{Error: fmt.Sprintf("unknown action %q", request.Action)}
+}
+
+// resolveTorrents maps hash prefixes, exact names or "all" to the hashes of
+// torrents in the session. A torrent matched by several selectors is
+// listed once.
+func resolveTorrents(engine TorrentEngine, selectors []string) ([]string, error) {
+	if len(selectors) == 0 {
+		return nil, fmt.Errorf("no torrents given")
+	}
+	torrents, err := engine.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var hashes []string
+	seen := map[string]bool{}
+	for _, selector := range selectors {
+		matched := false
+		for _, t := range torrents {
+			if selector == "all" || t.Name == selector || (len(selector) >= 6 && strings.HasPrefix(t.Hash, strings.ToLower(selector))) {
+				matched = true
+				if !seen[t.Hash] {
+					seen[t.Hash] = true
+					hashes = append(hashes, t.Hash)
+				}
+			}
+		}
+		if !matched {
+			return nil, fmt.Errorf("no torrent matches %q", selector)
+		}
+	}
+	return hashes, nil
+}
+
+// RunControlCommand sends a single control command to the session behind
+// the socket and prints its response. When no session is listening, the
+// command is run against the engine on the droplet of -ip or of the latest
+// run. It returns the process exit code.
+func RunControlCommand(action string, args []string) int {
+	flags := commandFlags(action, "[flags] [args...]")
+	load := configFlags(flags)
+	socketPath := flags.String("socket", "", "Control socket of the running session, control_socket of the configuration by default")
+	ip := flags.String("ip", "", "Public IP of the droplet when no session is running (defaults to the one of the latest run)")
+	flags.Parse(args)
+
+	conf, err := load()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if *socketPath == "" {
+		*socketPath = conf.ControlSocket
+		if *socketPath == "" {
+			*socketPath = defaultControlSocket()
+		}
+	}
+
+	request := controlRequest{Action: action, Args: flags.Args()}
+	var response controlResponse
+	if conn, err := net.Dial("unix", *socketPath); err == nil {
+		response, err = sendControlRequest(conn, request)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	} else {
+		logDebug("No running session found", "socket", *socketPath, "error", err)
+		response, err = dropletControl(conf, *ip, request)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "No running session found at %s, and the droplet can't be used: %v\n", *socketPath, redact(err.Error()))
+			return 1
+		}
+	}
+	if !response.Ok {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", redact(response.Error))
+		return 1
+	}
+
+	if response.Message != "" {
+		fmt.Println(response.Message)
+	}
+	if action == "status" {
+		if len(response.Torrents) == 0 {
+			fmt.Println("No torrents in the session.")
+		}
+		for _, t := range response.Torrents {
+			fmt.Printf("%.8s [%s] %s - %.2f%%\n", t.Hash, t.State, t.Name, t.Progress*100)
+		}
+	}
+	return 0
+}
+
+func sendControlRequest(conn net.Conn, request controlRequest) (controlResponse, error) {
+	defer conn.Close()
+	var response controlResponse
+	if err := json.NewEncoder(conn).Encode(request); err != nil {
+		return response, fmt.Errorf("Error sending command: %v", err)
+	}
+	if err := json.NewDecoder(bufio.NewReader(conn)).Decode(&response); err != nil {
+		return response, fmt.Errorf("Error reading response: %v", err)
+	}
+	return response, nil
+}
+
+// dropletControl carries out the request against the engine running on the
+// droplet of ip, or of the latest run, over SSH. It only attaches to the
+// engine and never sets it up. The torrents are recorded in the run's
+// journal, so that a later pull places them like a run would.
+func dropletControl(conf *config, ip string, request controlRequest) (controlResponse, error) {
+	journal, err := findJournal(ip)
+	if ip == "" {
+		if err != nil {
+			return controlResponse{}, fmt.Errorf("pass it with -ip, %v", err)
+		}
+		ip = journal.DropletIP
+	}
+	if conf.QbittorrentPassword == "" {
+		if journal == nil {
+			return controlResponse{}, fmt.Errorf("%v. Set qbittorrent_password to the password of the droplet's torrent client", err)
+		}
+		conf.QbittorrentPassword = journal.QbittorrentPassword
+		registerSecret(conf.QbittorrentPassword)
+	}
+
+	sshClient := NewSshClient(ip, "22", "root", conf.SshPrivateKeyPath)
+	conn, err := sshClient.Dial()
+	if err != nil {
+		return controlResponse{}, fmt.Errorf("error connecting to %v: %v", ip, err)
+	}
+	conn.Close()
+	engine, err := NewTorrentEngine(conf, sshClient)
+	if err != nil {
+		return controlResponse{}, err
+	}
+	if err := engine.Attach(); err != nil {
+		return controlResponse{}, err
+	}
+
+	response := dispatchControl(engine, request)
+	if journal != nil {
+		if torrents, err := engine.List(); err == nil {
+			journal.RecordTorrents(torrents)
+			if err := journal.Save(); err != nil {
+				logWarn("Error saving the run journal", "error", err)
+			}
+		}
+	}
+	return response, nil
+}
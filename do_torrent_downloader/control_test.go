@@ -0,0 +1,63 @@
+package doTorrentDownloader
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDispatchControl(t *testing.T) {
+	list := output(`[
+		{"hash": "aaaaaa1111", "name": "Show", "state": "downloading"},
+		{"hash": "aaaaaa2222", "name": "Movie", "state": "pausedUP", "progress": 1}
+	]`)
+	tests := []struct {
+		request controlRequest
+		// post is the part of the API call expected, if any.
+		post    string
+		message string
+		err     string
+	}{
+		{request: controlRequest{Action: "add", Args: []string{"magnet:?xt=urn:btih:abc"}}, post: "torrents/add", message: "Added 1 torrent(s)."},
+		{request: controlRequest{Action: "add"}, err: "no magnet links given"},
+		{request: controlRequest{Action: "pause", Args: []string{"Show", "AAAAAA1111"}}, post: "hashes=aaaaaa1111'", message: "Paused 1 torrent(s)."},
+		{request: controlRequest{Action: "resume", Args: []string{"all"}}, post: "hashes=aaaaaa1111|aaaaaa2222'", message: "Resumed 2 torrent(s)."},
+		{request: controlRequest{Action: "remove", Args: []string{"Movie"}}, post: "hashes=aaaaaa2222&deleteFiles=true", message: "Removed 1 torrent(s)."},
+		{request: controlRequest{Action: "pause", Args: []string{"aaaaa"}}, err: `no torrent matches "aaaaa"`},
+		{request: controlRequest{Action: "pause"}, err: "no torrents given"},
+		{request: controlRequest{Action: "stop"}, err: `unknown action "stop"`},
+	}
+	for _, test := range tests {
+		client := &fakeSshClient{responses: map[string]func() string{"torrents/info": list, "-X POST": output("200")}}
+		response := dispatchControl(newQbittorrentEngine(testConfig(), client), test.request)
+		if response.Ok != (test.err == "") || response.Error != test.err || response.Message != test.message {
+			t.Errorf("%v %v: got %+v, want message %q and error %q", test.request.Action, test.request.Args, response, test.message, test.err)
+		}
+		posted := ""
+		for _, cmd := range client.commands {
+			if strings.Contains(cmd, "-X POST") {
+				posted = cmd
+			}
+		}
+		if (test.post == "") != (posted == "") || !strings.Contains(posted, test.post) {
+			t.Errorf("%v %v: posted %q, want %q", test.request.Action, test.request.Args, posted, test.post)
+		}
+	}
+
+	client := &fakeSshClient{responses: map[string]func() string{"torrents/info": list}}
+	response := dispatchControl(newQbittorrentEngine(testConfig(), client), controlRequest{Action: "status"})
+	if !response.Ok || len(response.Torrents) != 2 || response.Torrents[1].State != StateCompleted {
+		t.Errorf("status: got %+v", response)
+	}
+}
+
+func TestDropletControlWithoutDroplet(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	request := controlRequest{Action: "status"}
+
+	if _, err := dropletControl(defaultConfig(), "", request); err == nil || !strings.Contains(err.Error(), "pass it with -ip") {
+		t.Errorf("without journals failed with %v, want to be asked for -ip", err)
+	}
+	if _, err := dropletControl(defaultConfig(), "192.0.2.1", request); err == nil || !strings.Contains(err.Error(), "Set qbittorrent_password") {
+		t.Errorf("without a journal of the droplet failed with %v, want to be asked for the password", err)
+	}
+}
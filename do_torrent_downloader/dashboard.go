@@ -0,0 +1,550 @@
+package doTorrentDownloader
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/digitalocean/godo"
+	"golang.org/x/term"
+)
+
+// dashboardLogLines is the number of output lines kept by the dashboard.
+// The last of them are shown below the torrents and all are printed once
+// the dashboard is closed.
+const dashboardLogLines = 1000
+
+// statsInterval is how often the droplet's stats are sampled.
+const statsInterval = 5 * time.Second
+
+// torrentView shows the torrents while waiting for the downloads.
+type torrentView interface {
+	Show(title string, torrents []Torrent)
+	// Notice logs a message along with the view.
+	Notice(level logLevel, message string, fields ...interface{})
+	Close()
+}
+
+// newTorrentView returns the dashboard when stdin and stdout are terminals
+// and neither -plain nor -output json is set, else plain status lines.
+func newTorrentView(engine TorrentEngine, sshClient SshClientOp, droplet *godo.Droplet, hourlyPrice float64, dir string) torrentView {
+	if plainOutput || events != nil || !isTerminal(os.Stdout) || !isTerminal(os.Stdin) {
+		return &logView{}
+	}
+	dash, err := startDashboard(engine, sshClient, droplet, hourlyPrice, dir)
+	if err != nil {
+		logWarn("Dashboard disabled", "error", err)
+		return &logView{}
+	}
+	return dash
+}
+
+func isTerminal(file *os.File) bool {
+	return term.IsTerminal(int(file.Fd()))
+}
+
+// logView prints the torrents as status lines, in place on a terminal.
+type logView struct {
+	renderer statusRenderer
+}
+
+func (view *logView) Show(title string, torrents []Torrent) {
+	view.renderer.Render(title, torrentRows(torrents))
+}
+
+func (view *logView) Notice(level logLevel, message string, fields ...interface{}) {
+	view.renderer.Reset()
+	logger.write(level, message, fields)
+}
+
+func (view *logView) Close() {}
+
+// dropletStats are the usage figures of the droplet. CPU and the rates are
+// averages since the previous sample.
+type dropletStats struct {
+	CPU      float64
+	DiskFree int64
+	DiskSize int64
+	RxRate   float64
+	TxRate   float64
+
+	cpuTotal, cpuIdle int64
+	rx, tx            int64
+	sampled           time.Time
+}
+
+// sampleDropletStats reads the CPU, the disk of dir and the network
+// counters of the droplet.
+func sampleDropletStats(sshClient SshClientOp, dir string, previous dropletStats) (dropletStats, bool) {
+	output := sshClient.executeCmd(fmt.Sprintf("head -1 /proc/stat; { df -B1 --output=size,avail %v 2>/dev/null || df -B1 --output=size,avail /; } | tail -1; cat /proc/net/dev", shellQuote(dir)))
+	lines := strings.Split(output, "\n")
+	if len(lines) < 3 {
+		return previous, false
+	}
+	stats := dropletStats{sampled: time.Now()}
+
+	// cpu user nice system idle iowait irq softirq steal ...
+	cpu := strings.Fields(lines[0])
+	if len(cpu) < 5 || cpu[0] != "cpu" {
+		return previous, false
+	}
+	for i, field := range cpu[1:] {
+		value, _ := strconv.ParseInt(field, 10, 64)
+		stats.cpuTotal += value
+		if i == 3 || i == 4 {
+			stats.cpuIdle += value
+		}
+	}
+
+	if disk := strings.Fields(lines[1]); len(disk) == 2 {
+		stats.DiskSize, _ = strconv.ParseInt(disk[0], 10, 64)
+		stats.DiskFree, _ = strconv.ParseInt(disk[1], 10, 64)
+	}
+
+	// iface: rx_bytes packets errs drop fifo frame compressed multicast tx_bytes ...
+	for _, line := range lines[2:] {
+		iface, counters, found := strings.Cut(line, ":")
+		iface = strings.TrimSpace(iface)
+		fields := strings.Fields(counters)
+		// Container traffic is counted again on the droplet's interface.
+		if !found || len(fields) < 9 || iface == "lo" || strings.HasPrefix(iface, "docker") ||
+			strings.HasPrefix(iface, "veth") || strings.HasPrefix(iface, "br-") {
+			continue
+		}
+		rx, _ := strconv.ParseInt(fields[0], 10, 64)
+		tx, _ := strconv.ParseInt(fields[8], 10, 64)
+		stats.rx += rx
+		stats.tx += tx
+	}
+
+	if !previous.sampled.IsZero() {
+		if total := stats.cpuTotal - previous.cpuTotal; total > 0 {
+			stats.CPU = 100 * float64(total-(stats.cpuIdle-previous.cpuIdle)) / float64(total)
+		}
+		if elapsed := stats.sampled.Sub(previous.sampled).Seconds(); elapsed > 0 {
+			stats.RxRate = float64(stats.rx-previous.rx) / elapsed
+			stats.TxRate = float64(stats.tx-previous.tx) / elapsed
+		}
+	}
+	return stats, true
+}
+
+// dashboard is a full-screen view of the torrents, the droplet's stats and
+// its cost so far. The output printed while it is open is captured and
+// shown below the torrents. Keys select a torrent and pause, resume or
+// remove it.
+type dashboard struct {
+	engine      TorrentEngine
+	sshClient   SshClientOp
+	droplet     *godo.Droplet
+	hourlyPrice float64
+	created     time.Time
+	dir         string
+
+	mu       sync.Mutex
+	title    string
+	torrents []Torrent
+	stats    dropletStats
+	hasStats bool
+	selected int
+	// removing is the hash of the torrent whose removal is asked for.
+	removing string
+	logs     []string
+	closed   bool
+	// plain takes over once the dashboard is closed with q.
+	plain *logView
+
+	terminal *os.File
+	// stderr is restored along with stdout once the dashboard is closed.
+	stderr   *os.File
+	state    *term.State
+	capture  *os.File
+	captured chan struct{}
+	redraw   chan struct{}
+	stop     chan struct{}
+}
+
+// startDashboard switches the terminal to the dashboard.
+func startDashboard(engine TorrentEngine, sshClient SshClientOp, droplet *godo.Droplet, hourlyPrice float64, dir string) (*dashboard, error) {
+	dash := &dashboard{
+		engine:      engine,
+		sshClient:   sshClient,
+		droplet:     droplet,
+		hourlyPrice: hourlyPrice,
+		dir:         dir,
+		terminal:    os.Stdout,
+		stderr:      os.Stderr,
+		captured:    make(chan struct{}),
+		redraw:      make(chan struct{}, 1),
+		stop:        make(chan struct{}),
+	}
+	dash.created, _ = time.Parse(time.RFC3339, droplet.Created)
+
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	dash.state, err = term.MakeRaw(int(os.Stdin.Fd()))
+	if err != nil {
+		reader.Close()
+		writer.Close()
+		return nil, err
+	}
+	dash.capture = writer
+	os.Stdout = writer
+	os.Stderr = writer
+	// Alternate screen, hidden cursor.
+	fmt.Fprint(dash.terminal, "\033[?1049h\033[?25l")
+
+	go dash.captureOutput(reader)
+	go dash.readKeys()
+	go dash.sampleStats()
+	go dash.loop()
+	return dash, nil
+}
+
+// captureOutput keeps the lines printed while the dashboard is open.
+func (dash *dashboard) captureOutput(reader *os.File) {
+	defer close(dash.captured)
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := stripEscapes(scanner.Text())
+		if line == "" {
+			continue
+		}
+		dash.mu.Lock()
+		dash.logs = append(dash.logs, line)
+		if len(dash.logs) > dashboardLogLines {
+			dash.logs = dash.logs[len(dash.logs)-dashboardLogLines:]
+		}
+		dash.mu.Unlock()
+		dash.requestRedraw()
+	}
+}
+
+// stripEscapes removes terminal escape sequences and carriage returns.
+func stripEscapes(line string) string {
+	var stripped strings.Builder
+	for i := 0; i < len(line); i++ {
+		switch {
+		case line[i] == '\033' && i+1 < len(line) && line[i+1] == '[':
+			i += 2
+			for i < len(line) && (line[i] < '@' || line[i] > '~') {
+				i++
+			}
+		case line[i] == '\r':
+		default:
+			stripped.WriteByte(line[i])
+		}
+	}
+	return strings.TrimSpace(stripped.String())
+}
+
+func (dash *dashboard) readKeys() {
+	buffer := make([]byte, 16)
+	for {
+		n, err := os.Stdin.Read(buffer)
+		if err != nil {
+			return
+		}
+		dash.mu.Lock()
+		closed := dash.closed
+		dash.mu.Unlock()
+		if closed {
+			return
+		}
+		dash.handleKey(string(buffer[:n]))
+	}
+}
+
+func (dash *dashboard) handleKey(key string) {
+	if key == "\x03" { // Ctrl-C, not a signal in raw mode.
+		dash.Close()
+		if process, err := os.FindProcess(os.Getpid()); err != nil || process.Signal(os.Interrupt) != nil {
+			os.Exit(130)
+		}
+		return
+	}
+	if key == "q" {
+		dash.Close()
+		logInfo("Dashboard closed, printing status lines.")
+		return
+	}
+
+	dash.mu.Lock()
+	defer dash.mu.Unlock()
+	defer dash.requestRedraw()
+
+	if dash.removing != "" {
+		if key == "y" || key == "Y" {
+			go dash.run("Removing", dash.removing, func(hashes []string) error { return dash.engine.Remove(hashes, true) })
+		}
+		dash.removing = ""
+		return
+	}
+	var torrent *Torrent
+	if dash.selected < len(dash.torrents) {
+		torrent = &dash.torrents[dash.selected]
+	}
+	switch key {
+	case "\033[A", "k":
+		if dash.selected > 0 {
+			dash.selected--
+		}
+	case "\033[B", "j":
+		if dash.selected < len(dash.torrents)-1 {
+			dash.selected++
+		}
+	case "p":
+		if torrent != nil {
+			go dash.run("Pausing", torrent.Hash, dash.engine.Pause)
+		}
+	case "r":
+		if torrent != nil {
+			go dash.run("Resuming", torrent.Hash, dash.engine.Resume)
+		}
+	case "d":
+		if torrent != nil {
+			dash.removing = torrent.Hash
+		}
+	}
+}
+
+// run runs an action of a key on a torrent, printing its outcome to the
+// log of the dashboard.
+func (dash *dashboard) run(action string, hash string, do func(hashes []string) error) {
+	logInfo(action, "hash", hash)
+	if err := do([]string{hash}); err != nil {
+		logError(action+" failed", "hash", hash, "error", err)
+	}
+}
+
+func (dash *dashboard) sampleStats() {
+	ticker := time.NewTicker(statsInterval)
+	defer ticker.Stop()
+	for {
+		dash.mu.Lock()
+		previous := dash.stats
+		dash.mu.Unlock()
+		stats, ok := sampleDropletStats(dash.sshClient, dash.dir, previous)
+		dash.mu.Lock()
+		if ok {
+			dash.stats, dash.hasStats = stats, true
+		}
+		dash.mu.Unlock()
+		dash.requestRedraw()
+
+		select {
+		case <-dash.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (dash *dashboard) requestRedraw() {
+	select {
+	case dash.redraw <- struct{}{}:
+	default:
+	}
+}
+
+// loop redraws on changes and every second for the cost counter.
+func (dash *dashboard) loop() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-dash.stop:
+			return
+		case <-ticker.C:
+		case <-dash.redraw:
+		}
+		dash.mu.Lock()
+		if !dash.closed {
+			dash.draw()
+		}
+		dash.mu.Unlock()
+	}
+}
+
+func (dash *dashboard) Show(title string, torrents []Torrent) {
+	dash.mu.Lock()
+	if dash.plain != nil {
+		dash.mu.Unlock()
+		dash.plain.Show(title, torrents)
+		return
+	}
+	dash.title, dash.torrents = title, torrents
+	if dash.selected >= len(torrents) && len(torrents) > 0 {
+		dash.selected = len(torrents) - 1
+	}
+	dash.mu.Unlock()
+	dash.requestRedraw()
+}
+
+func (dash *dashboard) Notice(level logLevel, message string, fields ...interface{}) {
+	dash.mu.Lock()
+	plain := dash.plain
+	dash.mu.Unlock()
+	if plain != nil {
+		plain.Notice(level, message, fields...)
+		return
+	}
+	logger.write(level, message, fields)
+}
+
+// Close restores the terminal and prints the captured output.
+func (dash *dashboard) Close() {
+	dash.mu.Lock()
+	if dash.closed {
+		dash.mu.Unlock()
+		return
+	}
+	dash.closed = true
+	dash.plain = &logView{}
+	close(dash.stop)
+	dash.mu.Unlock()
+
+	os.Stdout = dash.terminal
+	os.Stderr = dash.stderr
+	dash.capture.Close()
+	<-dash.captured
+	fmt.Fprint(dash.terminal, "\033[?25h\033[?1049l")
+	term.Restore(int(os.Stdin.Fd()), dash.state)
+
+	dash.mu.Lock()
+	defer dash.mu.Unlock()
+	for _, line := range dash.logs {
+		fmt.Println(line)
+	}
+}
+
+// draw draws the whole screen. It is called with the lock held.
+func (dash *dashboard) draw() {
+	width, height, err := term.GetSize(int(dash.terminal.Fd()))
+	if err != nil {
+		width, height = 80, 24
+	}
+	if height < 8 {
+		height = 8
+	}
+	var lines []string
+	add := func(format string, args ...interface{}) {
+		lines = append(lines, fmt.Sprintf(format, args...))
+	}
+
+	ip, _ := dash.droplet.PublicIPv4()
+	region := ""
+	if dash.droplet.Region != nil {
+		region = dash.droplet.Region.Slug
+	}
+	title := dash.title
+	if title == "" {
+		title = "Waiting for torrents"
+	}
+	add("\033[1mdo-torrent-downloader\033[0m  %v", title)
+	add("Droplet %v  %v  %v  %v", dash.droplet.Name, ip, dash.droplet.SizeSlug, region)
+	if dash.hasStats {
+		add("CPU %5.1f%%   Disk %v free of %v   Net in %v/s out %v/s", dash.stats.CPU,
+			formatBytes(dash.stats.DiskFree), formatBytes(dash.stats.DiskSize),
+			formatBytes(int64(dash.stats.RxRate)), formatBytes(int64(dash.stats.TxRate)))
+	} else {
+		add("CPU, disk and network: sampling...")
+	}
+	if !dash.created.IsZero() {
+		elapsed := time.Since(dash.created)
+		add("Cost $%.4f   Up %v at $%.5f/hour", dash.hourlyPrice*elapsed.Hours(), elapsed.Round(time.Second), dash.hourlyPrice)
+	}
+	add("")
+
+	// The progress bar is left out when the name wouldn't fit next to it.
+	const fixedWidth, barWidth, minNameWidth = 70, 20, 10
+	bar := barWidth + 3
+	nameWidth := width - fixedWidth - bar
+	if nameWidth < minNameWidth {
+		bar, nameWidth = 0, width-fixedWidth
+	}
+	if nameWidth < minNameWidth {
+		nameWidth = minNameWidth
+	}
+	add("  %-11v %-*v %-*v%7v %11v %11v %7v %5v %8v", "STATE", nameWidth, "NAME", bar, "", "DONE",
+		"DOWN", "UP", "SEED/PR", "RATIO", "ETA")
+	for i, t := range dash.torrents {
+		progress := ""
+		if bar > 0 {
+			filled := int(t.Progress * barWidth)
+			if filled > barWidth {
+				filled = barWidth
+			}
+			progress = "[" + strings.Repeat("#", filled) + strings.Repeat("-", barWidth-filled) + "] "
+		}
+		line := fmt.Sprintf("%-11v %-*v %v%6.1f%% %11v %11v %7v %5.2f %8v",
+			truncate(t.State, 11), nameWidth, truncate(t.Name, nameWidth), progress, t.Progress*100,
+			formatBytes(t.Dlspeed)+"/s", formatBytes(t.Upspeed)+"/s",
+			fmt.Sprintf("%d/%d", t.Seeds, t.Peers), t.Ratio(), formatEta(t.Eta))
+		if i == dash.selected {
+			lines = append(lines, "\033[7m> "+truncate(line, width-2)+"\033[0m")
+		} else {
+			lines = append(lines, "  "+line)
+		}
+	}
+
+	var footer []string
+	if dash.removing != "" {
+		footer = append(footer, fmt.Sprintf("\033[1mRemove %v and its data? y/n\033[0m", dash.removing))
+	} else {
+		footer = append(footer, "\033[2m↑/↓ select  p pause  r resume  d remove  q status lines  ctrl-c quit\033[0m")
+	}
+
+	// The remaining rows show the end of the captured output.
+	room := height - len(lines) - len(footer) - 2
+	if room > 0 && len(dash.logs) > 0 {
+		add("")
+		logs := dash.logs
+		if len(logs) > room {
+			logs = logs[len(logs)-room:]
+		}
+		for _, line := range logs {
+			add("%v", line)
+		}
+	}
+	for len(lines) < height-len(footer) {
+		add("")
+	}
+	lines = append(lines[:height-len(footer)], footer...)
+
+	var screen strings.Builder
+	screen.WriteString("\033[H")
+	for i, line := range lines {
+		if !strings.HasPrefix(line, "\033") {
+			line = truncate(line, width)
+		}
+		screen.WriteString(line)
+		screen.WriteString("\033[K")
+		if i < len(lines)-1 {
+			screen.WriteString("\r\n")
+		}
+	}
+	fmt.Fprint(dash.terminal, screen.String())
+}
+
+// truncate shortens s to width runes, ending it with ... when cut.
+func truncate(s string, width int) string {
+	if width <= 0 {
+		return ""
+	}
+	if utf8.RuneCountInString(s) <= width {
+		return s
+	}
+	runes := []rune(s)
+	if width <= 3 {
+		return string(runes[:width])
+	}
+	return string(runes[:width-3]) + "..."
+}
@@ -0,0 +1,173 @@
+package doTorrentDownloader
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultDiskReserve is the free space kept on the local disk unless
+// transfer.disk_reserve is set.
+const defaultDiskReserve = 1 << 30
+
+// diskGuard pauses the transfer while the free space on the local disk is
+// below the reserve, so that it can be freed instead of the transfer
+// failing.
+type diskGuard struct {
+	reserve uint64
+	notify  func(string)
+
+	mu      sync.Mutex
+	checked map[string]time.Time
+}
+
+// diskReserve returns the reserve of the config.
+func diskReserve(conf *config) (uint64, error) {
+	if conf.Transfer.DiskReserve == "" {
+		return defaultDiskReserve, nil
+	}
+	reserve, err := parseSize(conf.Transfer.DiskReserve)
+	if err != nil {
+		return 0, fmt.Errorf("invalid disk_reserve: %v", err)
+	}
+	return uint64(reserve), nil
+}
+
+// newDiskGuard returns nil when there is no reserve to keep.
+func newDiskGuard(conf *config) (*diskGuard, error) {
+	reserve, err := diskReserve(conf)
+	if err != nil || reserve == 0 {
+		return nil, err
+	}
+	return &diskGuard{
+		reserve: reserve,
+		notify:  func(message string) { logInfo(message) },
+		checked: map[string]time.Time{},
+	}, nil
+}
+
+// Check blocks while the filesystem of dir has less free space than the
+// reserve. The free space is looked up at most once a second per directory.
+func (guard *diskGuard) Check(dir string) {
+	guard.mu.Lock()
+	defer guard.mu.Unlock()
+
+	if time.Since(guard.checked[dir]) < time.Second {
+		return
+	}
+	paused := false
+	for {
+		free, _, err := diskUsage(dir)
+		if err != nil || free >= guard.reserve {
+			break
+		}
+		if !paused {
+			guard.notify(fmt.Sprintf("Transfer paused: %v free on the disk of %v, below the reserve of %v. Free some space to continue, the droplet is kept meanwhile.",
+				formatBytes(int64(free)), dir, formatBytes(int64(guard.reserve))))
+			paused = true
+		}
+		time.Sleep(30 * time.Second)
+	}
+	if paused {
+		guard.notify("Enough disk space again, resuming the transfer.")
+	}
+	guard.checked[dir] = time.Now()
+}
+
+// guardedWriter checks the disk space before writing.
+type guardedWriter struct {
+	writer io.Writer
+	guard  *diskGuard
+	dir    string
+}
+
+func (w *guardedWriter) Write(p []byte) (int, error) {
+	w.guard.Check(w.dir)
+	return w.writer.Write(p)
+}
+
+// existingDir returns the closest directory of the path that exists, where
+// the free space of a path not created yet is looked up.
+func existingDir(localPath string) string {
+	dir := localPath
+	for {
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return dir
+		}
+		dir = parent
+	}
+}
+
+// filesystemSpace is what a transfer needs on one local filesystem.
+type filesystemSpace struct {
+	dir    string
+	free   uint64
+	needed uint64
+}
+
+// checkDiskSpace compares the free space of the local filesystems the jobs
+// are transferred to with the size of the files still to be transferred
+// plus the reserve.
+func checkDiskSpace(conf *config, sshClient SshClientOp, jobs []TransferJob) error {
+	reserve, err := diskReserve(conf)
+	if err != nil {
+		return err
+	}
+
+	filesystems := map[string]*filesystemSpace{}
+	dirs := map[string]string{}
+	for _, job := range jobs {
+		files, err := listRemoteFiles(sshClient, job)
+		if err != nil {
+			continue
+		}
+		for _, file := range files {
+			localPath, ok := job.Rules.Place(job, file.name, file.size)
+			if !ok {
+				continue
+			}
+			needed := file.size
+			if info, err := os.Stat(localPath); err == nil && info.Size() <= file.size {
+				// Partial files are resumed.
+				needed -= info.Size()
+			}
+
+			dir := existingDir(filepath.Dir(localPath))
+			id, ok := dirs[dir]
+			if !ok {
+				free, fsID, err := diskUsage(dir)
+				if err != nil {
+					return fmt.Errorf("error checking the free space of %v: %v", dir, err)
+				}
+				id = fsID
+				dirs[dir] = id
+				if filesystems[id] == nil {
+					filesystems[id] = &filesystemSpace{dir: dir, free: free}
+				}
+			}
+			filesystems[id].needed += uint64(needed)
+		}
+	}
+
+	var problems []string
+	for _, space := range filesystems {
+		if space.needed+reserve > space.free {
+			problems = append(problems, fmt.Sprintf("%v is needed on the disk of %v plus a reserve of %v, but only %v is free",
+				formatBytes(int64(space.needed)), space.dir, formatBytes(int64(reserve)), formatBytes(int64(space.free))))
+		}
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("not enough local disk space: %v", strings.Join(problems, "; "))
+	}
+	return nil
+}
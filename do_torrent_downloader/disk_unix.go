@@ -0,0 +1,18 @@
+//go:build linux || darwin || freebsd
+
+package doTorrentDownloader
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// diskUsage returns the space available to the user on the filesystem of
+// dir and an identifier of the filesystem.
+func diskUsage(dir string) (uint64, string, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return 0, "", err
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize), fmt.Sprintf("%v", stat.Fsid), nil
+}
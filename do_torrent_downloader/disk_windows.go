@@ -0,0 +1,31 @@
+//go:build windows
+
+package doTorrentDownloader
+
+import (
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+var getDiskFreeSpaceEx = syscall.NewLazyDLL("kernel32.dll").NewProc("GetDiskFreeSpaceExW")
+
+// diskUsage returns the space available to the user on the volume of dir
+// and an identifier of the volume.
+func diskUsage(dir string) (uint64, string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return 0, "", err
+	}
+	path, err := syscall.UTF16PtrFromString(dir)
+	if err != nil {
+		return 0, "", err
+	}
+	var free uint64
+	ok, _, err := getDiskFreeSpaceEx.Call(uintptr(unsafe.Pointer(path)), uintptr(unsafe.Pointer(&free)), 0, 0)
+	if ok == 0 {
+		return 0, "", err
+	}
+	return free, strings.ToLower(filepath.VolumeName(dir)), nil
+}
@@ -5,8 +5,6 @@ import (
 	"flag"
 	"fmt"
 	"os"
-	"os/exec"
-	"strconv"
 	"strings"
 	"time"
 
@@ -28,39 +26,46 @@ var magnetLinks arrayFlags
 var dropletIp string
 var downloadDir string
 var dropletSize string
-var showVersion bool
-var cleanRemote bool
 var isDebugModeOn bool
-var rsyncOnly bool
+var controlSocket string
+var showPassword bool
+var bandwidthLimit string
+var configFile string
+var profileName string
+var outputFormat string
+var plainOutput bool
 var droplet *godo.Droplet
 
-func setAndParseFlags() {
-	flag.Var(&magnetLinks, "m", "Torrent magnet link.")
-	flag.StringVar(&dropletIp, "ip", "", "Public IP of an already running droplet.")
-	flag.StringVar(&downloadDir, "dir", "", "Download to directory (overrides what is set in the config file)")
-	flag.StringVar(&dropletSize, "size", "", "Size slug of the droplet (overrides what is set in the config file)")
-	flag.BoolVar(&showVersion, "v", false, "prints current version")
-	flag.BoolVar(&cleanRemote, "cleanRemote", false, "Delete all droplets with the configured tag")
-	flag.BoolVar(&isDebugModeOn, "debug", false, "enable debug mode")
-	flag.BoolVar(&rsyncOnly, "rsyncOnly", false, "Skip torrent client setup and simply rsync from the droplet")
-	flag.Parse()
-}
+// Modes of runSession.
+const (
+	// sessionRun downloads, transfers and deletes the droplet.
+	sessionRun = "run"
+	// sessionCreate sets up the droplet and adds the torrents.
+	sessionCreate = "create"
+	// sessionPull transfers what is complete on the droplet and deletes it.
+	sessionPull = "pull"
+)
 
-func getTerminalWidth() int {
-	cmd := exec.Command("stty", "size")
-	cmd.Stdin = os.Stdin
-	out, err := cmd.Output()
-	if err != nil {
-		return 80 // default
+// sessionFlags registers the flags of the session subcommands of the mode.
+func sessionFlags(flags *flag.FlagSet, mode string) {
+	if mode != sessionPull {
+		flags.Var(&magnetLinks, "m", "Torrent magnet link. Append |<dir> to transfer the torrent to dir.")
+		flags.StringVar(&dropletSize, "size", "", "Size slug of the droplet (overrides what is set in the config file)")
+		flags.BoolVar(&showPassword, "showPassword", false, "Print the WebUI password of the run")
 	}
-	parts := strings.Fields(string(out))
-	if len(parts) >= 2 {
-		width, err := strconv.Atoi(parts[1])
-		if err == nil {
-			return width
-		}
+	if mode != sessionCreate {
+		flags.StringVar(&dropletIp, "ip", "", "Public IP of an already running droplet.")
+		flags.StringVar(&downloadDir, "dir", "", "Download to directory (overrides what is set in the config file)")
+		flags.StringVar(&bandwidthLimit, "bwlimit", "", "Transfer bandwidth limit, e.g. 2MB/s (overrides what is set in the config file)")
 	}
-	return 80
+	if mode == sessionRun {
+		flags.StringVar(&controlSocket, "socket", "", "Path of the control socket (overrides what is set in the config file)")
+		flags.BoolVar(&plainOutput, "plain", false, "Print status lines instead of the dashboard")
+	}
+	flags.BoolVar(&isDebugModeOn, "debug", false, "Print debug messages, e.g. the commands run on the droplet")
+	flags.StringVar(&configFile, "config", "", "Path of the configuration file (.yml, .yaml or .json)")
+	flags.StringVar(&profileName, "profile", "", "Name of the configuration profile to use")
+	flags.StringVar(&outputFormat, "output", outputText, "Output format: text, or json for one JSON event per line on stdout")
 }
 
 func optionsForQbit() string {
@@ -72,13 +77,39 @@ func optionsForQbit() string {
 }
 
 func RealMain() {
-	setAndParseFlags()
-	if showVersion {
-		PrintVersion()
-		return
+	os.Exit(runCLI(os.Args[1:]))
+}
+
+// runSession runs the droplet session of the mode with the parsed session
+// flags: sessionRun creates a droplet, or attaches to the one of -ip, and
+// downloads, transfers and deletes it, sessionCreate stops once the torrents
+// are added, and sessionPull transfers from the droplet of -ip.
+func runSession(mode string) (code int) {
+	code = 1
+	rsyncOnly := mode == sessionPull
+
+	switch outputFormat {
+	case outputText, "":
+	case outputJSON:
+		startEventStream()
+	default:
+		logError(fmt.Sprintf("Unknown output format %q, use text or json.", outputFormat))
+		return 2
 	}
+	emit("run_started", runStartedEvent{Mode: mode, Version: Version})
+	defer func() {
+		status := "failed"
+		if code == 0 {
+			status = "success"
+		}
+		emit("run_finished", runFinishedEvent{Status: status, ExitCode: code})
+	}()
 
-	config := LoadConfiguration("do-torrent-downloader.yml")
+	config, err := LoadConfiguration(configFile, profileName)
+	if err != nil {
+		fatalf("%v", err)
+		return
+	}
 	if downloadDir != "" {
 		// Override with argument
 		config.DownloadDir = downloadDir
@@ -87,30 +118,104 @@ func RealMain() {
 		// Override with argument
 		config.Size = dropletSize
 	}
+	if controlSocket != "" {
+		// Override with argument
+		config.ControlSocket = controlSocket
+	}
+	if config.ControlSocket == "" {
+		config.ControlSocket = defaultControlSocket()
+	}
+	if bandwidthLimit != "" {
+		// Override with argument
+		config.Transfer.BandwidthLimit = bandwidthLimit
+	}
+	if err := checkConfig(config); err != nil {
+		fatalf("%v", err)
+		return
+	}
+	level, _ := parseLogLevel(config.Log.Level)
+	if isDebugModeOn {
+		level = levelDebug
+	}
+	setLogLevel(level)
+	logPath, err := openRunLog(config, mode)
+	if err != nil {
+		logWarn("Not writing a log file", "error", err)
+	} else {
+		defer func() {
+			if code != 0 {
+				logInfo("The log of this run is at " + logPath)
+			}
+			closeRunLog()
+		}()
+	}
+	logDebug("Run started", "mode", mode, "version", Version, "args", strings.Join(os.Args[1:], " "))
+
+	notifier, _ := newNotifier(config)
+	var torrents []Torrent
+	// dropletRunning is set while a failure would leave the droplet running.
+	dropletRunning := false
+	defer func() {
+		if code != 0 {
+			notifier.Notify(notifyFailed, torrents)
+		}
+		if dropletRunning {
+			notifier.Notify(notifyDropletRunning, torrents)
+		}
+	}()
+	rules, err := newTransferRules(config)
+	if err != nil {
+		fatalf("%v", err)
+		return
+	}
+	seeding, _ := newSeedingPolicy(config)
+	links, destinations, err := magnetDestinations(magnetLinks)
+	if err != nil {
+		fatalf("%v", err)
+		return
+	}
+	magnetLinks = links
 
-	fmt.Println("\nRunning with the following config:")
-	fmt.Println(config)
-	fmt.Println("")
+	logInfo("\nRunning with the following config:\n" + config.String())
 
 	InitDoClient(config.DigitalOceanPat)
 
-	if cleanRemote {
-		fmt.Printf("Cleaning up droplets with tag: %s\n", config.DropletTag)
-		DeleteDropletsByTag(config.DropletTag)
-		return
+	if rsyncOnly && dropletIp == "" {
+		latest, err := LatestJournal()
+		if err != nil {
+			fatalf("Pass the droplet with -ip: %v", err)
+			return
+		}
+		dropletIp = latest.DropletIP
+		logInfo("Pulling from the droplet of the latest run", "ip", dropletIp)
 	}
 
 	if dropletIp == "" { // No droplet ID passed.
-		fmt.Println("Create a new droplet")
-		droplet, _ = CreateDroplet(config)
+		emitSetupStep("preflight", "started", "")
+		if !printPreflight(preflight(config)) {
+			emitSetupStep("preflight", "failed", "")
+			fatalf("Fix the errors above, nothing was created. Check again with: do_torrent_downloader validate")
+			return
+		}
+		emitSetupStep("preflight", "done", "")
+		logInfo("Create a new droplet")
+		droplet, err = CreateDroplet(config)
+		if err != nil {
+			fatalf("Error creating the droplet: %v", err)
+			return
+		}
+		emitDroplet("droplet_created", droplet)
 		// Wait until the droplet is active
 		for i := 0; i < 30; i++ {
 			// refresh the droplet status
-			droplet, _, _ = DoClient.Droplets.Get(context.TODO(), droplet.ID)
+			if refreshed, _, err := DoClient.Droplets.Get(context.TODO(), droplet.ID); err == nil {
+				droplet = refreshed
+			}
 
-			fmt.Printf("Droplet status: %v \r\n", droplet.Status)
+			logInfo("Waiting for the droplet", "status", droplet.Status)
 			if droplet.Status == "active" {
-				fmt.Println("Droplet's now active")
+				logInfo("Droplet's now active")
+				emitDroplet("droplet_active", droplet)
 				break
 			} else {
 				time.Sleep(5 * time.Second)
@@ -119,65 +224,163 @@ func RealMain() {
 		time.Sleep(30 * time.Second)
 	} else {
 		droplet = GetByIp(dropletIp)
+		if droplet == nil {
+			fatalf("No droplet with the IP %v found in the account.", dropletIp)
+			return
+		}
+		emitDroplet("droplet_attached", droplet)
 	}
+	defer func() { emitCost(droplet, config.Size) }()
+	dropletRunning = true
+	hourlyPrice := HourlyPrice(droplet, config.Size)
 
 	ip, _ := droplet.PublicIPv4()
-	fmt.Printf("Droplet IPv4 %v \n", ip)
+	logInfo("Droplet ready", "id", droplet.ID, "ip", ip)
+
+	journal, err := LoadJournal(droplet.ID)
+	newJournal := err != nil
+	if newJournal {
+		journal = NewJournal(droplet.ID, ip)
+	}
+	setEventRunID(journal.RunID)
+	setLogRunID(journal.RunID)
+	notifier.SetDroplet(droplet, hourlyPrice, journal.RunID)
+	defer notifier.WatchBudget()()
+	if logPath != "" {
+		journal.LogFiles = append(journal.LogFiles, logPath)
+	}
+	if config.QbittorrentPassword == "" {
+		// No password configured: reuse the one of the run being attached
+		// to or generate a new one for this run.
+		if journal.QbittorrentPassword == "" {
+			journal.QbittorrentPassword, err = generateRandomPassword(24)
+			if err != nil {
+				fatalf("Error generating the WebUI password: %v", err)
+				return
+			}
+		}
+		config.QbittorrentPassword = journal.QbittorrentPassword
+		registerSecret(config.QbittorrentPassword)
+	} else {
+		journal.QbittorrentPassword = config.QbittorrentPassword
+	}
+	journal.MagnetLinks = append(journal.MagnetLinks, magnetLinks...)
+	if journal.Destinations == nil {
+		journal.Destinations = map[string]string{}
+	}
+	for hash, dir := range destinations {
+		journal.Destinations[hash] = dir
+	}
+	if err := journal.Save(); err != nil {
+		logWarn("Error saving the run journal", "error", err)
+	}
 
-	sshClient := NewSshClient(ip, "22", "root", config.SshPrivateKeyPath, isDebugModeOn)
+	sshClient := NewSshClient(ip, "22", "root", config.SshPrivateKeyPath)
 	// delete firewall rules preventing SSH access
+	emitSetupStep("firewall", "started", "")
 	sshClient.executeCmd("sudo ufw allow ssh || true && sudo ufw reload")
 	sshClient.executeCmd("sudo ufw delete limit 22/tcp || true")
+	emitSetupStep("firewall", "done", "")
 
-	var err error
-	if !rsyncOnly {
-		sshClient.SetupQbittorrent(config)
-
-		var sid string
+	engine, err := NewTorrentEngine(config, sshClient)
+	if err != nil {
+		fatalf("%v", err)
+		return
+	}
 
-		sid, err = sshClient.GetAuthSidForQbitAPI(config.QbittorrentPassword)
+	if !rsyncOnly {
+		emitSetupStep("engine", "started", engine.Name())
+		err = engine.Setup(dropletIp != "")
 		if err != nil {
-			fmt.Printf("Error authenticating: %v\n", err)
+			emitSetupStep("engine", "failed", engine.Name())
+			if newJournal && dropletIp != "" {
+				// The droplet was created elsewhere and the password
+				// generated for it doesn't work.
+				DeleteJournal(droplet.ID)
+			}
+			fatalf("Error setting up %v: %v", engine.Name(), redact(err.Error()))
 			return
 		}
+		emitSetupStep("engine", "done", engine.Name())
 
 		if len(magnetLinks) > 0 {
-			sshClient.AddTorrents(magnetLinks, sid)
-			fmt.Printf("Torrents added. Monitor at: http://%v:8080\n", ip)
+			if err := engine.Add(magnetLinks); err != nil {
+				logError("Error adding torrents", "error", err)
+				emitError("Error adding torrents: "+err.Error(), false)
+			} else {
+				for _, link := range magnetLinks {
+					hash := magnetInfoHash(link)
+					emit("torrent_added", torrentAddedEvent{Link: link, Hash: hash, Destination: destinations[hash]})
+				}
+			}
+			if engine.WebUIPort() != 0 {
+				logInfo(fmt.Sprintf("Torrents added. Monitor at: http://%v:%d", ip, engine.WebUIPort()))
+			}
+		} else {
+			logInfo("No magnet links provided. Only starting the torrent client.")
+		}
+		if engine.WebUIPort() != 0 {
+			if showPassword {
+				// Printed only, the log would redact it.
+				fmt.Printf("WebUI login: admin / %v\n", config.QbittorrentPassword)
+			} else {
+				logInfo("Run `do_torrent_downloader password` to get the WebUI password.")
+			}
+		}
+		if mode == sessionCreate {
+			logInfo(fmt.Sprintf("The droplet keeps running. Follow it with `do_torrent_downloader run -ip %v`, transfer with `do_torrent_downloader pull -ip %v` or delete it with `do_torrent_downloader destroy %v`.", ip, ip, ip))
+			dropletRunning = false
+			return 0
+		}
+	}
+
+	hooks, _ := newHookRunner(config, sshClient, journal.RunID)
+	runStatus := "failed"
+	defer func() {
+		hooks.RunFinished(runStatus, ip, torrents)
+		hooks.PrintSummary()
+	}()
+
+	if !rsyncOnly {
+		control, err := StartControlServer(config.ControlSocket, engine)
+		if err != nil {
+			logWarn("Control socket disabled", "error", err)
 		} else {
-			fmt.Println("No magnet links provided. Only starting the torrent client.")
+			logInfo("Control socket listening", "path", config.ControlSocket)
 		}
 
 		downloadsInProgress := true
 		waitForTorrentsCounter := 0
 		const maxWaitAttempts = 12 // 1 minute (12 * 5 seconds)
-		lastLinesPrinted := 0
+		view := newTorrentView(engine, sshClient, droplet, hourlyPrice, config.Qbit.CompletedDir)
+		var seedingSince time.Time
+		notifiedCompleted := false
 
 		for downloadsInProgress == true {
-			torrents, err := sshClient.GetTorrents(sid)
+			var err error
+			torrents, err = engine.List()
 			if err != nil {
-				lastLinesPrinted = 0
-				fmt.Printf("Error getting torrents: %v\n", err)
+				view.Notice(levelWarn, "Error getting torrents", "error", err)
+				emitError("Error getting torrents: "+err.Error(), false)
 				time.Sleep(5 * time.Second)
 				waitForTorrentsCounter++
 				if waitForTorrentsCounter >= maxWaitAttempts {
-					fmt.Println("Timeout waiting for torrents/connection. Exiting loop.")
+					view.Notice(levelError, "Timeout waiting for torrents/connection. Exiting loop.")
 					break
 				}
 				continue
 			}
 
 			if len(torrents) == 0 {
-				lastLinesPrinted = 0
 				if len(magnetLinks) > 0 {
-					fmt.Println("No torrents found yet...")
+					view.Notice(levelInfo, "No torrents found yet...")
 				} else {
-					fmt.Println("No torrents in list. Waiting...")
+					view.Notice(levelInfo, "No torrents in list. Waiting...")
 				}
 				time.Sleep(5 * time.Second)
 				waitForTorrentsCounter++
 				if waitForTorrentsCounter >= maxWaitAttempts {
-					fmt.Println("Timeout waiting for torrents to appear. Exiting loop.")
+					view.Notice(levelError, "Timeout waiting for torrents to appear. Exiting loop.")
 					break
 				}
 				continue
@@ -187,90 +390,140 @@ func RealMain() {
 			waitForTorrentsCounter = 0
 
 			allCompleted := true
-
-			if lastLinesPrinted > 0 {
-				fmt.Printf("\033[%dA", lastLinesPrinted)
-			}
-			fmt.Print("\033[2K\r--- Torrent Status ---\n")
-			termWidth := getTerminalWidth()
 			for _, t := range torrents {
-				speedMB := float64(t.Dlspeed) / 1024 / 1024
-				etaDuration := time.Duration(t.Eta) * time.Second
-				etaString := fmt.Sprintf("%dm:%ds", int(etaDuration.Minutes()), int(etaDuration.Seconds())%60)
-				if t.Eta == 8640000 { // qBittorrent returns 8640000 for infinity/unknown
-					etaString = "∞"
-				}
-
-				// fmt.Printf("\033[2K\r[%s] %s - %.2f%% - Speed: %.2f MB/s - ETA: %s\n", t.State, t.Name, t.Progress*100, speedMB, etaString)
-				// Construct parts to calculate length
-				prefix := fmt.Sprintf("[%s] ", t.State)
-				suffix := fmt.Sprintf(" - %.2f%% - Speed: %.2f MB/s - ETA: %s", t.Progress*100, speedMB, etaString)
-				
-				availableSpace := termWidth - len(prefix) - len(suffix)
-				name := t.Name
-				if availableSpace < 5 { // Minimal space fallback
-					// Just print as is or very short, but let's assume at least some space. 
-					// If strictly enforcing no wrap, we might hide name.
-					if len(name) > 10 {
-						name = name[:7] + "..."
-					}
-				} else if len(name) > availableSpace {
-					name = name[:availableSpace-3] + "..."
-				}
-
-				fmt.Printf("\033[2K\r%s%s%s\n", prefix, name, suffix)
-
-
-				// Check completion
-				// States: downloading, stalledDL, metaDL, pausedDL, queuedDL, allocating, uploading, stalledUP, pausedUP, queuedUP, moving, missingFiles, error
-				// We consider it done if it's seeding, uploading, pausedUP, or progress is 1.0
-				isComplete := false
-				if t.Progress >= 1.0 {
-					isComplete = true
-				}
-				// qBittorrent states for completion usually involve "UP" (uploading) or "pausedUP" (completed and paused)
-				if strings.Contains(t.State, "UP") || t.State == "uploading" || t.State == "stalledUP" {
-					isComplete = true
-				}
-
-				if !isComplete {
+				if !t.IsComplete() {
 					allCompleted = false
 				}
 			}
-			fmt.Print("\033[2K\r----------------------\n")
-			lastLinesPrinted = len(torrents) + 2
+			title := "Torrent Status"
+			if allCompleted && seeding != nil {
+				if seedingSince.IsZero() {
+					seedingSince = time.Now()
+				}
+				title = fmt.Sprintf("Seeding %v", seeding)
+			}
+			view.Show(title, torrents)
+			emitTorrentStatus(torrents, !seedingSince.IsZero())
+			hooks.TorrentsCompleted(torrents)
 
 			if allCompleted && len(torrents) > 0 {
-				fmt.Println("All downloads completed.")
+				if !notifiedCompleted {
+					notifiedCompleted = true
+					notifier.Notify(notifyCompleted, torrents)
+				}
+				if seeding != nil {
+					done, reason := seeding.Done(torrents, seedingSince)
+					if !done {
+						time.Sleep(5 * time.Second)
+						continue
+					}
+					logInfo(fmt.Sprintf("Stopped seeding, %v.", reason))
+				}
+				logInfo("All downloads completed.")
 				downloadsInProgress = false
 			} else {
 				time.Sleep(5 * time.Second)
 			}
 		}
+
+		view.Close()
+		if control != nil {
+			control.Close()
+		}
+		hooks.Wait()
+		journal.RecordTorrents(torrents)
+		if err := journal.Save(); err != nil {
+			logWarn("Error saving the run journal", "error", err)
+		}
+	} else {
+		// Place the torrents as the run that downloaded them would have.
+		torrents = journal.KnownTorrents()
+	}
+
+	var metadata map[string]torrentMetadata
+	if config.Transfer.VerifyPieces && !rsyncOnly && len(torrents) > 0 {
+		metadata = exportMetadata(engine, torrents)
 	}
 
 	// Stop seeding
-	sshClient.StopQbittorrent()
-
-	// Rsync the files: https://github.com/refola/golang/blob/master/backup/rsync.go
-	fmt.Printf("Rsync files down to %v\n", config.DownloadDir)
-	cmd := exec.Command(
-		"rsync",
-		"-e",
-		fmt.Sprintf("ssh -o StrictHostKeyChecking=no -i %v", config.SshPrivateKeyPath),
-		"-a",
-		"--partial",
-		"--progress",
-		fmt.Sprintf("%v@%v:%v/", "root", ip, config.Qbit.CompletedDir),
-		config.DownloadDir)
-	// show rsync's output
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	err = cmd.Run()
+	engine.Teardown()
+
+	var extractions map[string]extraction
+	if config.Extract.Enabled && !rsyncOnly {
+		emitSetupStep("extract", "started", "")
+		extractions = extractArchives(config, sshClient, torrents)
+		// The pieces of torrents whose archives were removed can't be verified.
+		for _, t := range torrents {
+			if extractions[t.Name].archivesRemoved {
+				delete(metadata, t.Hash)
+			}
+		}
+		emitSetupStep("extract", "done", "")
+	}
+
+	control, _ := newTransferControl(config, HourlyPrice(droplet, config.Size))
+	transferer, err := NewTransferer(config, sshClient, ip, control)
+	if err != nil {
+		fatalf("Error starting the transfer: %v", err)
+		return
+	}
+	jobs := transferJobs(config, sshClient, torrents, rules, journal.Destinations, extractions)
+	if config.Transfer.DiskCheck != "off" {
+		if err := checkDiskSpace(config, sshClient, jobs); err != nil {
+			logError(err.Error())
+			emitError(err.Error(), config.Transfer.DiskCheck != "warn")
+			if config.Transfer.DiskCheck != "warn" {
+				logInfo(fmt.Sprintf("Keeping the droplet. Free some space and resume the transfer with: do_torrent_downloader pull -ip %v", ip))
+				return
+			}
+		}
+	}
+	// Wait for a bandwidth window before the progress view is drawn.
+	control.Wait(config.DownloadDir)
+	logInfo(fmt.Sprintf("Transferring %d download(s) to %v with %v", len(jobs), config.DownloadDir, transferer.Name()))
+	tracker := newTransferTracker(jobs)
+	control.SetNotify(tracker.Notice)
+	err = transferer.Transfer(jobs, tracker.Update)
+	tracker.Finish()
 	if err != nil {
-		os.Stderr.WriteString(err.Error())
+		logError("Error transferring files", "error", err)
+		emitError("Error transferring files: "+err.Error(), true)
+		logInfo(fmt.Sprintf("Keeping the droplet. Resume the transfer with: do_torrent_downloader pull -ip %v", ip))
+		return
 	}
 
-	fmt.Println("Deleting the droplet...")
-	DoClient.Droplets.Delete(context.TODO(), droplet.ID)
+	if !config.Transfer.SkipVerify {
+		if err := verifyTransfer(config, sshClient, ip, jobs, control); err != nil {
+			logError(err.Error())
+			emitError(err.Error(), true)
+			logInfo(fmt.Sprintf("Keeping the droplet. Resume the transfer with: do_torrent_downloader pull -ip %v", ip))
+			return
+		}
+	}
+	if err := verifyJobPieces(jobs, metadata); err != nil {
+		logError(err.Error())
+		emitError(err.Error(), true)
+		logInfo(fmt.Sprintf("Keeping the droplet. Resume the transfer with: do_torrent_downloader pull -ip %v", ip))
+		return
+	}
+
+	for _, job := range jobs {
+		hooks.TorrentTransferred(job, torrents)
+	}
+	runStatus = "success"
+	notifier.Notify(notifyTransferred, torrents)
+
+	logInfo("Deleting the droplet...", "id", droplet.ID)
+	if _, err := DoClient.Droplets.Delete(context.TODO(), droplet.ID); err != nil {
+		// The droplet keeps costing money, so the run fails and the
+		// droplet_running notification goes out.
+		runStatus = "failed"
+		fatalf("Error deleting the droplet: %v. Delete it with `do_torrent_downloader destroy %v`", err, droplet.ID)
+		return
+	}
+	dropletRunning = false
+	emitDroplet("droplet_destroyed", droplet)
+	// The journal keeps the password of a droplet that is still running.
+	DeleteJournal(droplet.ID)
+	return 0
 }
@@ -30,18 +30,30 @@ func ListKeys() []godo.Key {
 	return keys
 }
 
-func FindKey(name string) godo.Key {
-	var ssh_key godo.Key
-	for _, key := range ListKeys() {
-		if key.Name == "bpi@casa.v.totakura.in" {
-			ssh_key = key
-			break
+// FindKey finds the SSH key of the account by its name or fingerprint.
+func FindKey(name string) (godo.Key, error) {
+	keys, _, err := DoClient.Keys.List(context.TODO(), &godo.ListOptions{PerPage: 200})
+	if err != nil {
+		return godo.Key{}, fmt.Errorf("error listing the SSH keys: %v", err)
+	}
+	var names []string
+	for _, key := range keys {
+		if key.Name == name || key.Fingerprint == name {
+			return key, nil
 		}
+		names = append(names, fmt.Sprintf("%q", key.Name))
+	}
+	if len(names) == 0 {
+		return godo.Key{}, fmt.Errorf("the account has no SSH keys. Add your public key at https://cloud.digitalocean.com/account/security and set ssh_key to its name")
 	}
-	return ssh_key
+	return godo.Key{}, fmt.Errorf("SSH key %q isn't in the account, set ssh_key to one of %v", name, strings.Join(names, ", "))
 }
 
 func CreateDroplet(config *config) (*godo.Droplet, error) {
+	key, err := FindKey(config.SshKey)
+	if err != nil {
+		return nil, err
+	}
 	createRequest := &godo.DropletCreateRequest{
 		Name:   config.DropletName,
 		Region: config.Region,
@@ -50,35 +62,48 @@ func CreateDroplet(config *config) (*godo.Droplet, error) {
 			Slug: config.ImageSlug,
 		},
 		SSHKeys: []godo.DropletCreateSSHKey{
-			{Fingerprint: FindKey(config.SshKey).Fingerprint},
+			{Fingerprint: key.Fingerprint},
 		},
 		Tags: []string{config.DropletTag},
 	}
 
+	logDebug("Creating the droplet", "name", config.DropletName, "region", config.Region, "size", config.Size, "image", config.ImageSlug, "key", key.Fingerprint)
 	newDroplet, _, err := DoClient.Droplets.Create(context.TODO(), createRequest)
-
 	if err != nil {
-		fmt.Printf("Something bad happened: %s\n\n", err)
 		return nil, err
 	}
+	logInfo("Droplet created", "id", newDroplet.ID, "name", newDroplet.Name)
 	return newDroplet, nil
 }
 
-func DeleteDropletsByTag(tag string) {
-	if tag == "" {
-		fmt.Println("No tag specified, skipping cleanup.")
-		return
+// confirmDeletion asks until the answer is yes or no.
+func confirmDeletion(question string) bool {
+	reader := bufio.NewReader(os.Stdin)
+	for {
+		fmt.Printf("%v [y/n]: ", question)
+		response, err := reader.ReadString('\n')
+		if err != nil && response == "" {
+			// No more input, e.g. not run from a terminal.
+			return false
+		}
+		response = strings.TrimSpace(strings.ToLower(response))
+
+		if response == "n" || response == "no" {
+			return false
+		} else if response == "y" || response == "yes" {
+			return true
+		}
 	}
+}
 
+// ListDropletsByTag returns all droplets with the tag.
+func ListDropletsByTag(tag string) ([]godo.Droplet, error) {
 	opt := &godo.ListOptions{PerPage: 200}
 	var allDroplets []godo.Droplet
-
-	// 1. Collect all droplets
 	for {
 		droplets, resp, err := DoClient.Droplets.ListByTag(context.TODO(), tag, opt)
 		if err != nil {
-			fmt.Printf("Error listing droplets by tag: %v\n", err)
-			return
+			return nil, err
 		}
 		allDroplets = append(allDroplets, droplets...)
 
@@ -91,9 +116,24 @@ func DeleteDropletsByTag(tag string) {
 		}
 		opt.Page = page + 1
 	}
+	return allDroplets, nil
+}
+
+func DeleteDropletsByTag(tag string) {
+	if tag == "" {
+		logWarn("No tag specified, skipping cleanup.")
+		return
+	}
+
+	// 1. Collect all droplets
+	allDroplets, err := ListDropletsByTag(tag)
+	if err != nil {
+		logError("Error listing droplets by tag", "tag", tag, "error", err)
+		return
+	}
 
 	if len(allDroplets) == 0 {
-		fmt.Printf("No droplets found with tag: %s\n", tag)
+		logInfo("No droplets found", "tag", tag)
 		return
 	}
 
@@ -106,34 +146,27 @@ func DeleteDropletsByTag(tag string) {
 		}())
 	}
 
-	reader := bufio.NewReader(os.Stdin)
-	for {
-		fmt.Print("Are you sure you want to delete them? [y/n]: ")
-		response, _ := reader.ReadString('\n')
-		response = strings.TrimSpace(strings.ToLower(response))
-
-		if response == "n" || response == "no" {
-			fmt.Println("Aborted.")
-			return
-		} else if response == "y" || response == "yes" {
-			break
-		}
+	if !confirmDeletion("Are you sure you want to delete them?") {
+		fmt.Println("Aborted.")
+		return
 	}
 
 	// 3. Delete droplets
 	for _, d := range allDroplets {
-		fmt.Printf("Deleting droplet: %s (ID: %d)\n", d.Name, d.ID)
+		logInfo("Deleting droplet", "name", d.Name, "id", d.ID)
 		_, err := DoClient.Droplets.Delete(context.TODO(), d.ID)
 		if err != nil {
-			fmt.Printf("Error deleting droplet %d: %v\n", d.ID, err)
+			logError("Error deleting droplet", "id", d.ID, "error", err)
 		} else {
-			fmt.Println("Deleted.")
+			DeleteJournal(d.ID)
+			logInfo("Deleted.", "id", d.ID)
 		}
 	}
 }
 
 func GetByIp(ip string) *godo.Droplet {
 
+	logDebug("Looking up the droplet", "ip", ip)
 	droplets, _, err := DoClient.Droplets.List(context.TODO(), &godo.ListOptions{PerPage: 200})
 	// TODO: Support searching with pagination.
 	if err != nil {
@@ -148,3 +181,24 @@ func GetByIp(ip string) *godo.Droplet {
 	}
 	return nil
 }
+
+// HourlyPrice returns the droplet's price per hour in USD, looked up in the
+// Sizes API by slug when the droplet doesn't carry its size.
+func HourlyPrice(droplet *godo.Droplet, sizeSlug string) float64 {
+	if droplet != nil && droplet.Size != nil && droplet.Size.PriceHourly > 0 {
+		return droplet.Size.PriceHourly
+	}
+	if droplet != nil && droplet.SizeSlug != "" {
+		sizeSlug = droplet.SizeSlug
+	}
+	sizes, _, err := DoClient.Sizes.List(context.TODO(), &godo.ListOptions{PerPage: 200})
+	if err != nil {
+		return 0
+	}
+	for _, size := range sizes {
+		if size.Slug == sizeSlug {
+			return size.PriceHourly
+		}
+	}
+	return 0
+}
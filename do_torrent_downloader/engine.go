@@ -0,0 +1,234 @@
+package doTorrentDownloader
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Normalized torrent states shared by all engines.
+const (
+	StateDownloading = "downloading"
+	StateStalled     = "stalled"
+	StateMetadata    = "metadata"
+	StateQueued      = "queued"
+	StateChecking    = "checking"
+	StatePaused      = "paused"
+	StateSeeding     = "seeding"
+	StateCompleted   = "completed"
+	StateError       = "error"
+)
+
+// EtaUnknown is the Eta of a torrent whose remaining time can't be estimated.
+const EtaUnknown = -1
+
+// Torrent is the engine independent status of a torrent.
+type Torrent struct {
+	Hash       string  `json:"hash"`
+	Name       string  `json:"name"`
+	Progress   float64 `json:"progress"`
+	Dlspeed    int64   `json:"dlspeed"`
+	Upspeed    int64   `json:"upspeed"`
+	Eta        int64   `json:"eta"`
+	State      string  `json:"state"`
+	Size       int64   `json:"size"`
+	Downloaded int64   `json:"downloaded"`
+	Uploaded   int64   `json:"uploaded"`
+	Category   string  `json:"category,omitempty"`
+	// Seeds and Peers are the connected seeds and other peers.
+	Seeds int `json:"seeds"`
+	Peers int `json:"peers"`
+}
+
+// Ratio is the share ratio of the torrent, the uploaded bytes per byte of
+// its size.
+func (t Torrent) Ratio() float64 {
+	size := t.Downloaded
+	if t.Size > size {
+		size = t.Size
+	}
+	if size == 0 {
+		return 0
+	}
+	return float64(t.Uploaded) / float64(size)
+}
+
+// IsComplete reports whether all the wanted data of the torrent has been
+// downloaded.
+func (t Torrent) IsComplete() bool {
+	return t.Progress >= 1.0 || t.State == StateSeeding || t.State == StateCompleted
+}
+
+// TorrentEngine is a torrent client running in a container on the droplet.
+// Engines are driven over SSH through the client's API on the droplet's
+// localhost, so the API doesn't need to be reachable from outside.
+type TorrentEngine interface {
+	Name() string
+	// WebUIPort is the port of the engine's web interface, 0 if it has none.
+	WebUIPort() int
+	// Setup starts the engine and logs in to its API. When attach is set,
+	// a healthy running engine is reused instead of being set up again.
+	Setup(attach bool) error
+	// Attach logs in to the engine already running on the droplet without
+	// setting anything up. It fails when no healthy engine is running.
+	Attach() error
+	Add(links []string) error
+	List() ([]Torrent, error)
+	Status(hash string) (Torrent, error)
+	Pause(hashes []string) error
+	Resume(hashes []string) error
+	Remove(hashes []string, deleteFiles bool) error
+	// Teardown stops and removes the engine's container to stop seeding.
+	Teardown()
+}
+
+// MetadataExporter is implemented by engines that can export the .torrent
+// metainfo of their torrents. unwanted holds the paths, relative to the
+// torrent's name, of the files that were not selected for download.
+type MetadataExporter interface {
+	ExportMetadata(hash string) (metainfo []byte, unwanted map[string]bool, err error)
+}
+
+// NewTorrentEngine returns the engine selected in the config.
+func NewTorrentEngine(conf *config, sshClient SshClientOp) (TorrentEngine, error) {
+	switch conf.Engine {
+	case "", "qbittorrent":
+		return newQbittorrentEngine(conf, sshClient), nil
+	case "transmission":
+		return newTransmissionEngine(conf, sshClient), nil
+	case "aria2":
+		return newAria2Engine(conf, sshClient), nil
+	}
+	return nil, fmt.Errorf("unknown torrent engine %q, use qbittorrent, transmission or aria2", conf.Engine)
+}
+
+// findTorrent returns the torrent with the given hash from the engine's list.
+func findTorrent(engine TorrentEngine, hash string) (Torrent, error) {
+	torrents, err := engine.List()
+	if err != nil {
+		return Torrent{}, err
+	}
+	for _, t := range torrents {
+		if strings.EqualFold(t.Hash, hash) {
+			return t, nil
+		}
+	}
+	return Torrent{}, fmt.Errorf("no torrent with hash %v", hash)
+}
+
+// engineImage returns the image configured with engine_image or the given
+// default.
+func engineImage(conf *config, defaultImage string) string {
+	if conf.EngineImage != "" {
+		return conf.EngineImage
+	}
+	return defaultImage
+}
+
+// containerHealthy reports whether the named container is running the given
+// image with the given volumes (host:container) and answers on the port.
+func containerHealthy(sshClient SshClientOp, name string, image string, volumes []string, port int) bool {
+	inspect := strings.TrimSpace(sshClient.executeCmd(fmt.Sprintf(
+		"docker inspect -f '{{.State.Running}} {{.Config.Image}} {{range .Mounts}}{{.Source}}:{{.Destination}} {{end}}' %s 2>/dev/null", name,
+	)))
+	fields := strings.Fields(inspect)
+	if len(fields) < 2 || fields[0] != "true" {
+		logInfo(fmt.Sprintf("No running %s container found.", name))
+		return false
+	}
+	if fields[1] != image {
+		logInfo(fmt.Sprintf("%s container runs %s instead of %s.", name, fields[1], image))
+		return false
+	}
+
+	mounts := fields[2:]
+	for _, expected := range volumes {
+		found := false
+		for _, mount := range mounts {
+			if mount == expected {
+				found = true
+				break
+			}
+		}
+		if !found {
+			logInfo(fmt.Sprintf("%s container is missing the volume %s.", name, expected))
+			return false
+		}
+	}
+
+	if sshClient.executeCmd(fmt.Sprintf("curl -s -I http://localhost:%d", port)) == "" {
+		logInfo(fmt.Sprintf("%s is not answering on port %d.", name, port))
+		return false
+	}
+	return true
+}
+
+// startContainer pulls the image and (re)starts the named container with the
+// given docker run arguments and optional command.
+func startContainer(sshClient SshClientOp, name string, image string, runArgs string, command string) {
+	logInfo(fmt.Sprintf("Pulling image: %s", image))
+	sshClient.executeCmd(fmt.Sprintf("docker pull %s", image))
+
+	logInfo(fmt.Sprintf("Stopping and removing existing %s container...", name))
+	removeContainer(sshClient, name)
+
+	logInfo(fmt.Sprintf("Starting %s container...", name))
+	out := sshClient.executeCmd(fmt.Sprintf("docker run -d --name=%s %s --restart unless-stopped %s %s", name, runArgs, image, command))
+	logDebug("Container started", "output", strings.TrimSpace(out))
+}
+
+// notRunningError is returned by Attach when the droplet has no healthy
+// engine to log in to.
+func notRunningError(name string) error {
+	return fmt.Errorf("no running %v with the configured image and directories found on the droplet", name)
+}
+
+// attachError is the error of a healthy container that can't be logged in
+// to. Setting it up again would stop its downloads, so the run stops
+// instead.
+func attachError(name string, err error) error {
+	return fmt.Errorf("could not log in to the running %v (%v). Its downloads are left alone. Set qbittorrent_password to its WebUI password, `do_torrent_downloader password -ip <ip>` prints it on the machine that created the droplet", name, err)
+}
+
+func removeContainer(sshClient SshClientOp, name string) {
+	sshClient.executeCmd(fmt.Sprintf("docker stop %s || true && docker rm %s || true", name, name))
+}
+
+// waitForPort waits up to a minute for something to answer on the port.
+func waitForPort(sshClient SshClientOp, port int) {
+	for i := 0; i < 12; i++ {
+		if sshClient.executeCmd(fmt.Sprintf("curl -s -I http://localhost:%d", port)) != "" {
+			return
+		}
+		time.Sleep(5 * time.Second)
+	}
+}
+
+// splitHTTPResponse splits the output of `curl -i` into the status code, the
+// headers of the last response and the body.
+func splitHTTPResponse(output string) (int, map[string]string, string) {
+	headers := map[string]string{}
+	status := 0
+	rest := output
+	// curl -i prints the headers of every response, e.g. of a 100 Continue
+	// before the final one, so keep reading header blocks while they last.
+	for strings.HasPrefix(rest, "HTTP/") {
+		block := rest
+		body := ""
+		if i := strings.Index(rest, "\r\n\r\n"); i >= 0 {
+			block, body = rest[:i], rest[i+4:]
+		}
+		lines := strings.Split(block, "\r\n")
+		if fields := strings.Fields(lines[0]); len(fields) >= 2 {
+			status, _ = strconv.Atoi(fields[1])
+		}
+		for _, line := range lines[1:] {
+			if parts := strings.SplitN(line, ":", 2); len(parts) == 2 {
+				headers[strings.ToLower(strings.TrimSpace(parts[0]))] = strings.TrimSpace(parts[1])
+			}
+		}
+		rest = body
+	}
+	return status, headers, rest
+}
@@ -0,0 +1,209 @@
+package doTorrentDownloader
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// fakeSshClient answers commands with the output of the first response whose
+// key is contained in the command.
+type fakeSshClient struct {
+	responses map[string]func() string
+	commands  []string
+}
+
+func (client *fakeSshClient) executeCmd(cmd string) string {
+	client.commands = append(client.commands, cmd)
+	for key, respond := range client.responses {
+		if strings.Contains(cmd, key) {
+			return respond()
+		}
+	}
+	return ""
+}
+
+func (client *fakeSshClient) Dial() (*ssh.Client, error) {
+	return nil, errors.New("the fake client can't dial")
+}
+
+func output(s string) func() string {
+	return func() string { return s }
+}
+
+func testConfig() *config {
+	conf := defaultConfig()
+	conf.QbittorrentPassword = "secret"
+	return conf
+}
+
+// checkTorrents compares the listed torrents, their ratios and completeness.
+func checkTorrents(t *testing.T, torrents []Torrent, want []Torrent, ratios []float64, complete []bool) {
+	t.Helper()
+	if !reflect.DeepEqual(torrents, want) {
+		t.Fatalf("listed\n%+v\nwant\n%+v", torrents, want)
+	}
+	for i, torrent := range torrents {
+		if got := torrent.Ratio(); got != ratios[i] {
+			t.Errorf("%v: Ratio() = %v, want %v", torrent.Name, got, ratios[i])
+		}
+		if got := torrent.IsComplete(); got != complete[i] {
+			t.Errorf("%v: IsComplete() = %v, want %v", torrent.Name, got, complete[i])
+		}
+	}
+}
+
+func TestQbittorrentList(t *testing.T) {
+	client := &fakeSshClient{responses: map[string]func() string{
+		"/api/v2/torrents/info": output(`[
+			{"hash": "aaa", "name": "Show", "progress": 0.5, "dlspeed": 1024, "upspeed": 512, "eta": 120,
+			 "state": "downloading", "size": 2000, "downloaded": 1000, "uploaded": 500, "category": "tv",
+			 "num_seeds": 4, "num_leechs": 2},
+			{"hash": "bbb", "name": "Movie", "progress": 1, "eta": 8640000, "state": "pausedUP",
+			 "size": 1000, "downloaded": 1000, "uploaded": 2000},
+			{"hash": "ccc", "name": "Stuck", "progress": 0.1, "eta": 8640000, "state": "stalledDL", "size": 1000},
+			{"hash": "ddd", "name": "New", "state": "someFutureState"},
+			{"hash": "eee", "name": "Gone", "progress": 0.2, "state": "missingFiles", "size": 1000, "downloaded": 200}
+		]`),
+	}}
+	engine := newQbittorrentEngine(testConfig(), client)
+	engine.sid = "session"
+
+	torrents, err := engine.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(client.commands[0], "SID=session") {
+		t.Errorf("listed without the session cookie: %v", client.commands[0])
+	}
+	checkTorrents(t, torrents, []Torrent{
+		{Hash: "aaa", Name: "Show", Progress: 0.5, Dlspeed: 1024, Upspeed: 512, Eta: 120, State: StateDownloading,
+			Size: 2000, Downloaded: 1000, Uploaded: 500, Category: "tv", Seeds: 4, Peers: 2},
+		{Hash: "bbb", Name: "Movie", Progress: 1, Eta: EtaUnknown, State: StateCompleted, Size: 1000, Downloaded: 1000, Uploaded: 2000},
+		{Hash: "ccc", Name: "Stuck", Progress: 0.1, Eta: EtaUnknown, State: StateStalled, Size: 1000},
+		{Hash: "ddd", Name: "New", State: "someFutureState"},
+		{Hash: "eee", Name: "Gone", Progress: 0.2, State: StateError, Size: 1000, Downloaded: 200},
+	}, []float64{0.25, 2, 0, 0, 0}, []bool{false, true, false, false, false})
+
+	client.responses["/api/v2/torrents/info"] = output("Forbidden")
+	if _, err := engine.List(); err == nil {
+		t.Error("List didn't fail on a rejected session")
+	}
+}
+
+func TestTransmissionList(t *testing.T) {
+	conflicts := 0
+	client := &fakeSshClient{responses: map[string]func() string{
+		"/transmission/rpc": func() string {
+			if conflicts == 0 {
+				conflicts++
+				return "HTTP/1.1 409 Conflict\r\nX-Transmission-Session-Id: abc\r\n\r\n<h1>409: Conflict</h1>"
+			}
+			return "HTTP/1.1 200 OK\r\nContent-Type: application/json\r\n\r\n" + `{"result": "success", "arguments": {"torrents": [
+				{"hashString": "aaa", "name": "Show", "percentDone": 0.5, "rateDownload": 1024, "rateUpload": 512, "eta": 120,
+				 "status": 4, "sizeWhenDone": 2000, "haveValid": 1000, "uploadedEver": 500, "metadataPercentComplete": 1,
+				 "labels": ["tv", "hd"], "peersSendingToUs": 4, "peersGettingFromUs": 2},
+				{"hashString": "bbb", "name": "Movie", "percentDone": 1, "eta": -1, "status": 0,
+				 "sizeWhenDone": 1000, "haveValid": 1000, "uploadedEver": 2000, "metadataPercentComplete": 1},
+				{"hashString": "ccc", "name": "Stuck", "percentDone": 0.1, "eta": -2, "status": 4,
+				 "sizeWhenDone": 1000, "metadataPercentComplete": 1},
+				{"hashString": "ddd", "name": "ddd", "eta": -1, "status": 4, "metadataPercentComplete": 0.5},
+				{"hashString": "eee", "name": "Seeding", "percentDone": 1, "eta": -1, "status": 6,
+				 "sizeWhenDone": 1000, "haveValid": 1000, "uploadedEver": 500, "metadataPercentComplete": 1},
+				{"hashString": "fff", "name": "Broken", "percentDone": 0.3, "eta": -1, "status": 4, "error": 3,
+				 "sizeWhenDone": 1000, "haveValid": 300, "metadataPercentComplete": 1},
+				{"hashString": "ggg", "name": "Paused", "percentDone": 0.3, "eta": -1, "status": 0,
+				 "sizeWhenDone": 1000, "haveValid": 300, "metadataPercentComplete": 1},
+				{"hashString": "hhh", "name": "Waiting", "eta": -1, "status": 3, "sizeWhenDone": 1000, "metadataPercentComplete": 1},
+				{"hashString": "iii", "name": "Checking", "eta": -1, "status": 2, "sizeWhenDone": 1000, "metadataPercentComplete": 1}
+			]}}`
+		},
+	}}
+	engine := newTransmissionEngine(testConfig(), client)
+
+	torrents, err := engine.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(client.commands) != 2 || !strings.Contains(client.commands[1], "X-Transmission-Session-Id: abc") {
+		t.Errorf("the session ID of the conflict wasn't retried with: %v", client.commands)
+	}
+	checkTorrents(t, torrents, []Torrent{
+		{Hash: "aaa", Name: "Show", Progress: 0.5, Dlspeed: 1024, Upspeed: 512, Eta: 120, State: StateDownloading,
+			Size: 2000, Downloaded: 1000, Uploaded: 500, Category: "tv", Seeds: 4, Peers: 2},
+		{Hash: "bbb", Name: "Movie", Progress: 1, Eta: EtaUnknown, State: StateCompleted, Size: 1000, Downloaded: 1000, Uploaded: 2000},
+		{Hash: "ccc", Name: "Stuck", Progress: 0.1, Eta: EtaUnknown, State: StateStalled, Size: 1000},
+		{Hash: "ddd", Name: "ddd", Eta: EtaUnknown, State: StateMetadata},
+		{Hash: "eee", Name: "Seeding", Progress: 1, Eta: EtaUnknown, State: StateSeeding, Size: 1000, Downloaded: 1000, Uploaded: 500},
+		{Hash: "fff", Name: "Broken", Progress: 0.3, Eta: EtaUnknown, State: StateError, Size: 1000, Downloaded: 300},
+		{Hash: "ggg", Name: "Paused", Progress: 0.3, Eta: EtaUnknown, State: StatePaused, Size: 1000, Downloaded: 300},
+		{Hash: "hhh", Name: "Waiting", Eta: EtaUnknown, State: StateQueued, Size: 1000},
+		{Hash: "iii", Name: "Checking", Eta: EtaUnknown, State: StateChecking, Size: 1000},
+	}, []float64{0.25, 2, 0, 0, 0.5, 0, 0, 0, 0}, []bool{false, true, false, false, true, false, false, false, false})
+
+	client.responses["/transmission/rpc"] = output("HTTP/1.1 401 Unauthorized\r\n\r\n")
+	if _, err := engine.List(); err == nil {
+		t.Error("List didn't fail on a rejected login")
+	}
+}
+
+func TestAria2List(t *testing.T) {
+	client := &fakeSshClient{responses: map[string]func() string{
+		"aria2.tellActive": output(`{"id": "do-torrent-downloader", "result": [
+			{"gid": "1", "status": "active", "totalLength": "2000", "completedLength": "1000", "uploadLength": "500",
+			 "downloadSpeed": "100", "uploadSpeed": "50", "numSeeders": "4", "connections": "6", "infoHash": "aaa",
+			 "seeder": "false", "bittorrent": {"info": {"name": "Show"}}},
+			{"gid": "2", "status": "active", "totalLength": "1000", "completedLength": "1000", "uploadLength": "2000",
+			 "downloadSpeed": "0", "uploadSpeed": "10", "numSeeders": "0", "connections": "3", "infoHash": "bbb",
+			 "seeder": "true", "bittorrent": {"info": {"name": "Movie"}}},
+			{"gid": "3", "status": "active", "totalLength": "0", "completedLength": "0", "uploadLength": "0",
+			 "downloadSpeed": "0", "uploadSpeed": "0", "numSeeders": "0", "connections": "0", "infoHash": "ccc",
+			 "seeder": "false", "bittorrent": {}},
+			{"gid": "4", "status": "active", "totalLength": "1000", "completedLength": "100", "uploadLength": "0",
+			 "downloadSpeed": "0", "uploadSpeed": "0", "numSeeders": "0", "connections": "0", "infoHash": "ddd",
+			 "seeder": "false", "bittorrent": {"info": {"name": "Stuck"}}}
+		]}`),
+		"aria2.tellWaiting": output(`{"id": "do-torrent-downloader", "result": [
+			{"gid": "5", "status": "paused", "totalLength": "1000", "completedLength": "300", "uploadLength": "0",
+			 "downloadSpeed": "0", "uploadSpeed": "0", "numSeeders": "0", "connections": "0", "infoHash": "eee",
+			 "seeder": "false", "bittorrent": {"info": {"name": "Paused"}}}
+		]}`),
+		"aria2.tellStopped": output(`{"id": "do-torrent-downloader", "result": [
+			{"gid": "6", "status": "complete", "totalLength": "0", "completedLength": "0", "uploadLength": "0",
+			 "downloadSpeed": "0", "uploadSpeed": "0", "numSeeders": "0", "connections": "0", "infoHash": "aaa",
+			 "seeder": "false", "followedBy": ["1"], "bittorrent": {}},
+			{"gid": "7", "status": "error", "totalLength": "1000", "completedLength": "10", "uploadLength": "0",
+			 "downloadSpeed": "0", "uploadSpeed": "0", "numSeeders": "0", "connections": "0", "infoHash": "fff",
+			 "seeder": "false", "bittorrent": {"info": {"name": "Broken"}}}
+		]}`),
+	}}
+	engine := newAria2Engine(testConfig(), client)
+
+	torrents, err := engine.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cmd := range client.commands {
+		if !strings.Contains(cmd, "token:secret") {
+			t.Errorf("called without the secret: %v", cmd)
+		}
+	}
+	checkTorrents(t, torrents, []Torrent{
+		{Hash: "aaa", Name: "Show", Progress: 0.5, Dlspeed: 100, Upspeed: 50, Eta: 10, State: StateDownloading,
+			Size: 2000, Downloaded: 1000, Uploaded: 500, Seeds: 4, Peers: 2},
+		{Hash: "bbb", Name: "Movie", Progress: 1, Upspeed: 10, Eta: EtaUnknown, State: StateSeeding,
+			Size: 1000, Downloaded: 1000, Uploaded: 2000, Peers: 3},
+		{Hash: "ccc", Name: "[METADATA] ccc", Eta: EtaUnknown, State: StateMetadata},
+		{Hash: "ddd", Name: "Stuck", Progress: 0.1, Eta: EtaUnknown, State: StateStalled, Size: 1000, Downloaded: 100},
+		{Hash: "eee", Name: "Paused", Progress: 0.3, Eta: EtaUnknown, State: StatePaused, Size: 1000, Downloaded: 300},
+		{Hash: "fff", Name: "Broken", Progress: 0.01, Eta: EtaUnknown, State: StateError, Size: 1000, Downloaded: 10},
+	}, []float64{0.25, 2, 0, 0, 0, 0}, []bool{false, true, false, false, false, false})
+
+	client.responses["aria2.tellActive"] = output(`{"id": "do-torrent-downloader", "error": {"code": 1, "message": "Unauthorized"}}`)
+	if _, err := engine.List(); err == nil {
+		t.Error("List didn't fail on a wrong secret")
+	}
+}
@@ -0,0 +1,207 @@
+package doTorrentDownloader
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/digitalocean/godo"
+)
+
+// eventSchemaVersion is the version of the events written with -output
+// json. It is increased when a field is removed or changes its meaning;
+// fields and events may be added within a version.
+const eventSchemaVersion = 1
+
+// Output formats of -output.
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
+// event is a line of the JSON event stream.
+type event struct {
+	Schema int         `json:"schema"`
+	Time   time.Time   `json:"time"`
+	Event  string      `json:"event"`
+	RunID  string      `json:"run_id,omitempty"`
+	Data   interface{} `json:"data,omitempty"`
+}
+
+// runStartedEvent is the data of run_started.
+type runStartedEvent struct {
+	Mode    string `json:"mode"`
+	Version string `json:"version"`
+}
+
+// dropletEvent is the data of droplet_created, droplet_active,
+// droplet_attached and droplet_destroyed.
+type dropletEvent struct {
+	ID     int    `json:"id"`
+	Name   string `json:"name"`
+	IP     string `json:"ip,omitempty"`
+	Region string `json:"region,omitempty"`
+	Size   string `json:"size,omitempty"`
+	Status string `json:"status,omitempty"`
+}
+
+// setupStepEvent is the data of setup_step. Status is started, done or
+// failed.
+type setupStepEvent struct {
+	Step   string `json:"step"`
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// torrentAddedEvent is the data of torrent_added.
+type torrentAddedEvent struct {
+	Link        string `json:"link"`
+	Hash        string `json:"hash,omitempty"`
+	Destination string `json:"destination,omitempty"`
+}
+
+// torrentSnapshot is a torrent of torrent_status.
+type torrentSnapshot struct {
+	Torrent
+	Ratio float64 `json:"ratio"`
+}
+
+// torrentStatusEvent is the data of torrent_status, a snapshot of all
+// torrents taken on every poll of the engine.
+type torrentStatusEvent struct {
+	Torrents []torrentSnapshot `json:"torrents"`
+	Seeding  bool              `json:"seeding"`
+}
+
+// transferProgressEvent is the data of transfer_progress, at most one per
+// second and torrent. Speed is in bytes per second.
+type transferProgressEvent struct {
+	Job        string  `json:"job"`
+	File       string  `json:"file,omitempty"`
+	BytesDone  int64   `json:"bytes_done"`
+	BytesTotal int64   `json:"bytes_total"`
+	FilesDone  int     `json:"files_done"`
+	FilesTotal int     `json:"files_total"`
+	Speed      float64 `json:"speed"`
+}
+
+// errorEvent is the data of error. Fatal errors end the run.
+type errorEvent struct {
+	Message string `json:"message"`
+	Fatal   bool   `json:"fatal"`
+}
+
+// costEvent is the data of cost, what the droplet has cost so far.
+type costEvent struct {
+	DropletID   int     `json:"droplet_id"`
+	HourlyPrice float64 `json:"hourly_price"`
+	Hours       float64 `json:"hours"`
+	Amount      float64 `json:"amount"`
+}
+
+// runFinishedEvent is the data of run_finished, the last event. Status is
+// success or failed.
+type runFinishedEvent struct {
+	Status   string `json:"status"`
+	ExitCode int    `json:"exit_code"`
+}
+
+// eventStream writes the events as JSON lines.
+type eventStream struct {
+	mu      sync.Mutex
+	encoder *json.Encoder
+	runID   string
+}
+
+// events is the stream of -output json, nil with the text output.
+var events *eventStream
+
+// startEventStream writes the events to stdout and moves the text output
+// to stderr, so that stdout only holds JSON lines.
+func startEventStream() {
+	events = &eventStream{encoder: json.NewEncoder(os.Stdout)}
+	os.Stdout = os.Stderr
+}
+
+// setEventRunID sets the run ID of the following events.
+func setEventRunID(runID string) {
+	if events == nil {
+		return
+	}
+	events.mu.Lock()
+	defer events.mu.Unlock()
+	events.runID = runID
+}
+
+// emit writes an event to the stream, if any.
+func emit(name string, data interface{}) {
+	if events == nil {
+		return
+	}
+	events.mu.Lock()
+	defer events.mu.Unlock()
+	events.encoder.Encode(event{
+		Schema: eventSchemaVersion,
+		Time:   time.Now().UTC(),
+		Event:  name,
+		RunID:  events.runID,
+		Data:   data,
+	})
+}
+
+func emitDroplet(name string, droplet *godo.Droplet) {
+	if events == nil || droplet == nil {
+		return
+	}
+	data := dropletEvent{ID: droplet.ID, Name: droplet.Name, Size: droplet.SizeSlug, Status: droplet.Status}
+	data.IP, _ = droplet.PublicIPv4()
+	if droplet.Region != nil {
+		data.Region = droplet.Region.Slug
+	}
+	emit(name, data)
+}
+
+func emitSetupStep(step string, status string, detail string) {
+	emit("setup_step", setupStepEvent{Step: step, Status: status, Detail: detail})
+}
+
+func emitTorrentStatus(torrents []Torrent, seeding bool) {
+	if events == nil {
+		return
+	}
+	snapshots := make([]torrentSnapshot, 0, len(torrents))
+	for _, t := range torrents {
+		snapshots = append(snapshots, torrentSnapshot{Torrent: t, Ratio: t.Ratio()})
+	}
+	emit("torrent_status", torrentStatusEvent{Torrents: snapshots, Seeding: seeding})
+}
+
+func emitError(message string, fatal bool) {
+	if fatal {
+		lastFatalError = redact(message)
+	}
+	emit("error", errorEvent{Message: redact(message), Fatal: fatal})
+}
+
+// emitCost emits what the droplet has cost since it was created.
+func emitCost(droplet *godo.Droplet, sizeSlug string) {
+	if events == nil || droplet == nil {
+		return
+	}
+	created, err := time.Parse(time.RFC3339, droplet.Created)
+	if err != nil {
+		return
+	}
+	price := HourlyPrice(droplet, sizeSlug)
+	hours := time.Since(created).Hours()
+	emit("cost", costEvent{DropletID: droplet.ID, HourlyPrice: price, Hours: hours, Amount: price * hours})
+}
+
+// fatalf logs the error that ends the run and emits it.
+func fatalf(format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	logError(message)
+	emitError(message, true)
+}
@@ -0,0 +1,213 @@
+package doTorrentDownloader
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const extractContainer = "extract"
+
+// archiveVolumePatterns recognise the volumes of archive sets. The first
+// submatch is the name of the set and the second the volume number, if any.
+var archiveVolumePatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)^(.+)\.part(\d+)\.rar$`),
+	regexp.MustCompile(`(?i)^(.+)\.(?:rar|r(\d\d))$`),
+	regexp.MustCompile(`(?i)^(.+)\.(?:zip|z(\d\d))$`),
+	regexp.MustCompile(`(?i)^(.+)\.7z(?:\.(\d{3}))?$`),
+}
+
+// archiveSet is a multi-part or single archive on the droplet, with paths
+// relative to the completed directory.
+type archiveSet struct {
+	first   string
+	volumes []string
+	// outputDir is where the set is extracted to.
+	outputDir string
+}
+
+// findArchiveSets groups the files of a torrent into archive sets, named by
+// the volume 7-Zip is started with.
+func findArchiveSets(files []string) []archiveSet {
+	sets := map[string]*archiveSet{}
+	var keys []string
+	for _, file := range files {
+		dir, base := path.Split(file)
+		for kind, pattern := range archiveVolumePatterns {
+			match := pattern.FindStringSubmatch(base)
+			if match == nil {
+				continue
+			}
+			key := fmt.Sprintf("%d:%s%s", kind, dir, match[1])
+			set, ok := sets[key]
+			if !ok {
+				set = &archiveSet{}
+				sets[key] = set
+				keys = append(keys, key)
+			}
+			set.volumes = append(set.volumes, file)
+			if isFirstVolume(kind, match[2]) {
+				set.first = file
+				set.outputDir = strings.TrimSuffix(dir, "/")
+				if set.outputDir == "" {
+					// A torrent that is a single archive gets a directory.
+					set.outputDir = match[1]
+				}
+			}
+			break
+		}
+	}
+
+	sort.Strings(keys)
+	var result []archiveSet
+	for _, key := range keys {
+		if set := sets[key]; set.first != "" {
+			sort.Strings(set.volumes)
+			result = append(result, *set)
+		}
+	}
+	return result
+}
+
+// isFirstVolume tells whether the volume number of a set of the given kind
+// is the one to extract from. .rar and .zip are the first volumes of their
+// old style sets, .part1.rar and .7z.001 of the numbered ones.
+func isFirstVolume(kind int, number string) bool {
+	if kind == 0 || (kind == 3 && number != "") {
+		n, _ := strconv.Atoi(number)
+		return n == 1
+	}
+	return number == ""
+}
+
+// extraction is the outcome of extracting the archives of a torrent.
+type extraction struct {
+	// dir is the top-level directory of the completed directory that holds
+	// the extracted files. It differs from the torrent's name when the
+	// torrent is a single archive.
+	dir string
+	// archivesRemoved tells whether archives were removed after their
+	// extraction, so that the torrent's pieces can't be verified.
+	archivesRemoved bool
+}
+
+// extractArchives extracts the archive sets of the completed torrents in a
+// helper container on the droplet, next to the archives. Unless the config
+// asks to transfer both, the archives are removed once their extraction
+// succeeded, so that only the extracted files are transferred. It returns
+// the extractions by torrent name.
+func extractArchives(conf *config, sshClient SshClientOp, torrents []Torrent) map[string]extraction {
+	extractions := map[string]extraction{}
+	dir := conf.Qbit.CompletedDir
+
+	type torrentSets struct {
+		name string
+		sets []archiveSet
+	}
+	var work []torrentSets
+	for _, t := range torrents {
+		files, err := listRemoteFiles(sshClient, TransferJob{Name: t.Name, RemotePath: path.Join(dir, t.Name)})
+		if err != nil {
+			continue
+		}
+		var names []string
+		for _, file := range files {
+			names = append(names, file.name)
+		}
+		if sets := findArchiveSets(names); len(sets) > 0 {
+			work = append(work, torrentSets{name: t.Name, sets: sets})
+		}
+	}
+	if len(work) == 0 {
+		return extractions
+	}
+
+	if err := startExtractContainer(conf, sshClient); err != nil {
+		logWarn("Skipping archive extraction", "error", err)
+		return extractions
+	}
+	defer removeContainer(sshClient, extractContainer)
+
+	for _, torrent := range work {
+		extracted := 0
+		result := extraction{dir: torrent.name}
+		for _, set := range torrent.sets {
+			logInfo(fmt.Sprintf("Extracting %v...", set.first))
+			if output, ok := extractSet(sshClient, dir, set); !ok {
+				logWarn(fmt.Sprintf("Extraction of %v failed, transferring the archive:\n%v", set.first, lastLines(output, 5)))
+				continue
+			}
+			extracted++
+			// The output directories of all sets are below the same
+			// top-level directory.
+			result.dir = strings.SplitN(set.outputDir, "/", 2)[0]
+			if conf.Extract.Transfer != "both" {
+				if err := removeArchiveSet(sshClient, dir, set); err != nil {
+					logWarn(fmt.Sprintf("Error removing the archives of %v, transferring them too", set.first), "error", err)
+					continue
+				}
+				result.archivesRemoved = true
+			}
+		}
+		logInfo(fmt.Sprintf("Extracted %d of %d archive set(s) of %v into %v.", extracted, len(torrent.sets), torrent.name, path.Join(dir, result.dir)))
+		if extracted > 0 {
+			extractions[torrent.name] = result
+		}
+	}
+	return extractions
+}
+
+// removeArchiveSet removes the volumes of the extracted set.
+func removeArchiveSet(sshClient SshClientOp, dir string, set archiveSet) error {
+	var quoted []string
+	for _, volume := range set.volumes {
+		quoted = append(quoted, shellQuote(path.Join(dir, volume)))
+	}
+	output := sshClient.executeCmd("rm -f " + strings.Join(quoted, " ") + " 2>&1 && echo DOTD_REMOVED")
+	if !strings.Contains(output, "DOTD_REMOVED") {
+		return fmt.Errorf("%v", strings.TrimSpace(output))
+	}
+	return nil
+}
+
+// startExtractContainer starts the helper container with 7-Zip and the
+// completed directory mounted.
+func startExtractContainer(conf *config, sshClient SshClientOp) error {
+	image, command := conf.Extract.Image, `sh -c "sleep infinity"`
+	if image == "" {
+		image, command = "alpine:3", `sh -c "apk add --no-cache 7zip && exec sleep infinity"`
+	}
+	dir := conf.Qbit.CompletedDir
+	startContainer(sshClient, extractContainer, image, fmt.Sprintf("-v %s:%s", dir, dir), command)
+
+	for i := 0; i < 24; i++ {
+		if strings.TrimSpace(sshClient.executeCmd(fmt.Sprintf("docker exec %s sh -c 'command -v 7z'", extractContainer))) != "" {
+			return nil
+		}
+		time.Sleep(5 * time.Second)
+	}
+	removeContainer(sshClient, extractContainer)
+	return fmt.Errorf("7z is not available in the %v container", image)
+}
+
+// extractSet tests the archive set and extracts it, not overwriting existing
+// files. It returns 7-Zip's output and whether both succeeded.
+func extractSet(sshClient SshClientOp, dir string, set archiveSet) (string, bool) {
+	archive := shellQuote(path.Join(dir, set.first))
+	script := fmt.Sprintf("7z t -y %s && mkdir -p %s && 7z x -y -aos -o%s %s && echo DOTD_EXTRACTED",
+		archive, shellQuote(path.Join(dir, set.outputDir)), shellQuote(path.Join(dir, set.outputDir)), archive)
+	output := sshClient.executeCmd(fmt.Sprintf("docker exec %s sh -c %s 2>&1", extractContainer, shellQuote(script)))
+	return output, strings.Contains(output, "DOTD_EXTRACTED")
+}
+
+func lastLines(output string, count int) string {
+	lines := strings.Split(strings.TrimRight(output, "\n"), "\n")
+	if len(lines) > count {
+		lines = lines[len(lines)-count:]
+	}
+	return strings.Join(lines, "\n")
+}
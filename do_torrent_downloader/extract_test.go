@@ -0,0 +1,72 @@
+package doTorrentDownloader
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFindArchiveSets(t *testing.T) {
+	sets := findArchiveSets([]string{
+		"Show/ep1.part2.rar", "Show/ep1.part1.rar", "Show/ep1.nfo",
+		"Show/Extras/extras.rar", "Show/Extras/extras.r00",
+		"movie.7z.001", "movie.7z.002",
+	})
+	want := []archiveSet{
+		{first: "Show/ep1.part1.rar", volumes: []string{"Show/ep1.part1.rar", "Show/ep1.part2.rar"}, outputDir: "Show"},
+		{first: "Show/Extras/extras.rar", volumes: []string{"Show/Extras/extras.r00", "Show/Extras/extras.rar"}, outputDir: "Show/Extras"},
+		{first: "movie.7z.001", volumes: []string{"movie.7z.001", "movie.7z.002"}, outputDir: "movie"},
+	}
+	if !reflect.DeepEqual(sets, want) {
+		t.Errorf("found\n%+v\nwant\n%+v", sets, want)
+	}
+}
+
+func TestRemoveArchiveSet(t *testing.T) {
+	set := archiveSet{first: "Show/a.rar", volumes: []string{"Show/a.r00", "Show/a.rar"}}
+
+	client := &fakeSshClient{responses: map[string]func() string{"rm -f": output("DOTD_REMOVED\n")}}
+	if err := removeArchiveSet(client, "/downloads/completed", set); err != nil {
+		t.Errorf("removeArchiveSet failed: %v", err)
+	}
+	if want := "rm -f '/downloads/completed/Show/a.r00' '/downloads/completed/Show/a.rar' 2>&1 && echo DOTD_REMOVED"; client.commands[0] != want {
+		t.Errorf("ran %q, want %q", client.commands[0], want)
+	}
+
+	client.responses["rm -f"] = output("rm: can't remove '/downloads/completed/Show/a.rar': Read-only file system\n")
+	if err := removeArchiveSet(client, "/downloads/completed", set); err == nil {
+		t.Error("removeArchiveSet didn't fail")
+	}
+}
+
+func TestTransferJobsExtracted(t *testing.T) {
+	client := &fakeSshClient{responses: map[string]func() string{"find": output("Show\nmovie\nmovie.7z.001\nother.mkv\nother.mkv.aria2\n")}}
+	conf := testConfig()
+	torrents := []Torrent{
+		{Name: "Show", Hash: "aaa", Category: "tv"},
+		{Name: "movie.7z.001", Hash: "bbb", Category: "films"},
+	}
+	extractions := map[string]extraction{
+		"Show":         {dir: "Show", archivesRemoved: true},
+		"movie.7z.001": {dir: "movie"},
+	}
+
+	jobs := transferJobs(conf, client, torrents, nil, map[string]string{"bbb": "/films"}, extractions)
+	dir := conf.Qbit.CompletedDir
+	want := []TransferJob{
+		{Name: "Show", Hash: "aaa", Category: "tv", RemotePath: dir + "/Show", LocalDir: conf.DownloadDir},
+		{Name: "movie", Hash: "bbb", Category: "films", RemotePath: dir + "/movie", LocalDir: "/films", Destination: "/films", Extracted: true},
+		{Name: "movie.7z.001", Hash: "bbb", Category: "films", RemotePath: dir + "/movie.7z.001", LocalDir: "/films", Destination: "/films"},
+		{Name: "other.mkv", RemotePath: dir + "/other.mkv", LocalDir: conf.DownloadDir},
+	}
+	if !reflect.DeepEqual(jobs, want) {
+		t.Errorf("jobs\n%+v\nwant\n%+v", jobs, want)
+	}
+
+	if tracker := newTransferTracker(jobs); tracker.torrents != 3 {
+		t.Errorf("the jobs are of %d torrent(s), want 3", tracker.torrents)
+	}
+	metadata := map[string]torrentMetadata{"bbb": {meta: &torrentMeta{Name: "movie.7z.001"}}}
+	if err := verifyJobPieces(jobs[1:2], metadata); err != nil {
+		t.Errorf("the pieces of the extracted job were verified: %v", err)
+	}
+}
@@ -0,0 +1,283 @@
+package doTorrentDownloader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	hookTorrentComplete    = "on_torrent_complete"
+	hookTorrentTransferred = "on_torrent_transferred"
+	hookRunFinished        = "on_run_finished"
+)
+
+const defaultHookTimeout = 10 * time.Minute
+
+// hookResult is the outcome of one hook command, shown in the run summary.
+type hookResult struct {
+	Event    string
+	Torrent  string
+	Command  string
+	Duration time.Duration
+	Output   string
+	Err      error
+	TimedOut bool
+}
+
+// hookRunner runs the commands configured for the lifecycle points of a
+// run. on_torrent_complete runs on the droplet, the others locally. Each
+// command gets the DOTD_* environment variables of its torrent and run.
+type hookRunner struct {
+	conf      *config
+	sshClient SshClientOp
+	runID     string
+	timeout   time.Duration
+
+	wg      sync.WaitGroup
+	mu      sync.Mutex
+	fired   map[string]bool
+	results []hookResult
+}
+
+func hookTimeout(conf *config) (time.Duration, error) {
+	if conf.Hooks.Timeout == "" {
+		return defaultHookTimeout, nil
+	}
+	timeout, err := time.ParseDuration(conf.Hooks.Timeout)
+	if err != nil || timeout <= 0 {
+		return 0, fmt.Errorf("invalid hooks timeout %q, use e.g. 10m", conf.Hooks.Timeout)
+	}
+	return timeout, nil
+}
+
+func newHookRunner(conf *config, sshClient SshClientOp, runID string) (*hookRunner, error) {
+	timeout, err := hookTimeout(conf)
+	if err != nil {
+		return nil, err
+	}
+	return &hookRunner{conf: conf, sshClient: sshClient, runID: runID, timeout: timeout, fired: map[string]bool{}}, nil
+}
+
+// torrentEnv is the environment of the hooks of a torrent.
+func (hooks *hookRunner) torrentEnv(event string, t Torrent, localPath string) []string {
+	return []string{
+		"DOTD_EVENT=" + event,
+		"DOTD_RUN_ID=" + hooks.runID,
+		"DOTD_TORRENT_NAME=" + t.Name,
+		"DOTD_TORRENT_HASH=" + t.Hash,
+		"DOTD_TORRENT_CATEGORY=" + t.Category,
+		fmt.Sprintf("DOTD_TORRENT_SIZE=%d", t.Size),
+		"DOTD_REMOTE_PATH=" + path.Join(hooks.conf.Qbit.CompletedDir, t.Name),
+		"DOTD_LOCAL_PATH=" + localPath,
+	}
+}
+
+// TorrentsCompleted starts the on_torrent_complete hooks on the droplet for
+// the completed torrents they haven't run for yet. They run in the
+// background; Wait waits for them.
+func (hooks *hookRunner) TorrentsCompleted(torrents []Torrent) {
+	if len(hooks.conf.Hooks.OnTorrentComplete) == 0 {
+		return
+	}
+	for _, t := range torrents {
+		if !t.IsComplete() || hooks.fired[t.Hash] {
+			continue
+		}
+		hooks.fired[t.Hash] = true
+		env := hooks.torrentEnv(hookTorrentComplete, t, "")
+		hooks.wg.Add(1)
+		go func(t Torrent) {
+			defer hooks.wg.Done()
+			for _, command := range hooks.conf.Hooks.OnTorrentComplete {
+				hooks.record(hooks.runRemote(hookTorrentComplete, t.Name, command, env))
+			}
+		}(t)
+	}
+}
+
+// Wait waits for the hooks running in the background.
+func (hooks *hookRunner) Wait() {
+	hooks.wg.Wait()
+}
+
+// TorrentTransferred runs the on_torrent_transferred hooks of a job. The
+// torrent of the job is looked up in torrents for its size.
+func (hooks *hookRunner) TorrentTransferred(job TransferJob, torrents []Torrent) {
+	t := Torrent{Name: job.Name, Hash: job.Hash, Category: job.Category}
+	for _, candidate := range torrents {
+		if job.Hash != "" && strings.EqualFold(candidate.Hash, job.Hash) {
+			t = candidate
+		}
+	}
+	env := hooks.torrentEnv(hookTorrentTransferred, t, job.Rules.TopPath(job))
+	for _, command := range hooks.conf.Hooks.OnTorrentTransferred {
+		hooks.record(hooks.runLocal(hookTorrentTransferred, job.Name, command, env))
+	}
+}
+
+// RunFinished runs the on_run_finished hooks with the status of the run.
+func (hooks *hookRunner) RunFinished(status string, dropletIP string, torrents []Torrent) {
+	var names []string
+	for _, t := range torrents {
+		names = append(names, t.Name)
+	}
+	env := []string{
+		"DOTD_EVENT=" + hookRunFinished,
+		"DOTD_RUN_ID=" + hooks.runID,
+		"DOTD_RUN_STATUS=" + status,
+		"DOTD_DROPLET_IP=" + dropletIP,
+		"DOTD_DOWNLOAD_DIR=" + hooks.conf.DownloadDir,
+		"DOTD_TORRENTS=" + strings.Join(names, "\n"),
+	}
+	for _, command := range hooks.conf.Hooks.OnRunFinished {
+		hooks.record(hooks.runLocal(hookRunFinished, "", command, env))
+	}
+}
+
+func (hooks *hookRunner) record(result hookResult) {
+	hooks.mu.Lock()
+	defer hooks.mu.Unlock()
+	hooks.results = append(hooks.results, result)
+}
+
+// runLocal runs the command with the system's shell.
+func (hooks *hookRunner) runLocal(event string, torrent string, command string, env []string) hookResult {
+	ctx, cancel := context.WithTimeout(context.Background(), hooks.timeout)
+	defer cancel()
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", command)
+	}
+	cmd.Env = append(os.Environ(), env...)
+
+	result := hookResult{Event: event, Torrent: torrent, Command: command}
+	// The output goes to a file rather than a pipe, so that a command that
+	// timed out isn't waited for until the processes it started are done.
+	output, err := ioutil.TempFile("", "do-torrent-downloader-hook")
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	defer os.Remove(output.Name())
+	defer output.Close()
+	cmd.Stdout = output
+	cmd.Stderr = output
+
+	started := time.Now()
+	result.Err = cmd.Run()
+	result.Duration = time.Since(started)
+	result.TimedOut = errors.Is(ctx.Err(), context.DeadlineExceeded)
+	if data, err := ioutil.ReadFile(output.Name()); err == nil {
+		result.Output = string(data)
+	}
+	return result
+}
+
+// runRemote runs the command on the droplet. It is stopped by timeout(1) on
+// the droplet and the connection is closed when that doesn't end it.
+func (hooks *hookRunner) runRemote(event string, torrent string, command string, env []string) (result hookResult) {
+	result = hookResult{Event: event, Torrent: torrent, Command: command}
+	started := time.Now()
+	defer func() { result.Duration = time.Since(started) }()
+
+	conn, err := hooks.sshClient.Dial()
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	defer conn.Close()
+	session, err := conn.NewSession()
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	defer session.Close()
+
+	var exports []string
+	for _, variable := range env {
+		parts := strings.SplitN(variable, "=", 2)
+		exports = append(exports, parts[0]+"="+shellQuote(parts[1]))
+	}
+	remoteCommand := fmt.Sprintf("%s timeout %d sh -c %s", strings.Join(exports, " "), int(hooks.timeout.Seconds()), shellQuote(command))
+
+	var output bytes.Buffer
+	session.Stdout = &output
+	session.Stderr = &output
+	timer := time.AfterFunc(hooks.timeout+30*time.Second, func() { conn.Close() })
+	defer timer.Stop()
+	err = session.Run(remoteCommand)
+
+	result.Output = output.String()
+	result.Err = err
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitStatus() == 124 {
+		result.TimedOut = true
+	}
+	if time.Since(started) > hooks.timeout {
+		result.TimedOut = true
+	}
+	return result
+}
+
+// PrintSummary prints the outcome of the hooks that ran, with the last lines
+// of the output of the ones that failed.
+func (hooks *hookRunner) PrintSummary() {
+	hooks.mu.Lock()
+	defer hooks.mu.Unlock()
+	if len(hooks.results) == 0 {
+		return
+	}
+
+	results := append([]hookResult{}, hooks.results...)
+	order := map[string]int{hookTorrentComplete: 0, hookTorrentTransferred: 1, hookRunFinished: 2}
+	sort.SliceStable(results, func(i, j int) bool { return order[results[i].Event] < order[results[j].Event] })
+
+	failed := 0
+	logInfo("--- Hooks ---")
+	for _, result := range results {
+		status := "ok"
+		switch {
+		case result.TimedOut:
+			status = fmt.Sprintf("timed out after %v", hooks.timeout)
+		case result.Err != nil:
+			status = fmt.Sprintf("failed: %v", result.Err)
+		}
+		label := result.Event
+		if result.Torrent != "" {
+			label += " " + result.Torrent
+		}
+		line := fmt.Sprintf("[%s] %s - %s (%v)", status, label, result.Command, result.Duration.Round(time.Second))
+		if result.Err == nil && !result.TimedOut {
+			logInfo(line)
+			continue
+		}
+		failed++
+		lines := strings.Split(strings.TrimRight(result.Output, "\n"), "\n")
+		if len(lines) > 5 {
+			lines = lines[len(lines)-5:]
+		}
+		for _, output := range lines {
+			if output != "" {
+				line += "\n    " + output
+			}
+		}
+		logWarn(line)
+	}
+	logInfo(fmt.Sprintf("%d hook(s) ran, %d failed.", len(results), failed))
+}
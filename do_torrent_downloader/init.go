@@ -0,0 +1,245 @@
+package doTorrentDownloader
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/digitalocean/godo"
+	"golang.org/x/term"
+	"gopkg.in/yaml.v2"
+)
+
+// initSizesShown is the number of the cheapest sizes offered by init. Any
+// other slug can be typed in.
+const initSizesShown = 20
+
+// wizard asks the questions of init on the terminal.
+type wizard struct {
+	reader *bufio.Reader
+}
+
+// ask asks for a value, returning the default on an empty answer.
+func (w *wizard) ask(question string, defaultValue string) string {
+	if defaultValue != "" {
+		fmt.Printf("%v [%v]: ", question, defaultValue)
+	} else {
+		fmt.Printf("%v: ", question)
+	}
+	answer, _ := w.reader.ReadString('\n')
+	if answer = strings.TrimSpace(answer); answer == "" {
+		return defaultValue
+	}
+	return answer
+}
+
+// askSecret asks for a value without echoing it when stdin is a terminal.
+func (w *wizard) askSecret(question string) string {
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return w.ask(question, "")
+	}
+	fmt.Printf("%v: ", question)
+	answer, _ := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Println()
+	return strings.TrimSpace(string(answer))
+}
+
+// confirm asks a yes/no question.
+func (w *wizard) confirm(question string, defaultYes bool) bool {
+	defaultValue := "y/N"
+	if defaultYes {
+		defaultValue = "Y/n"
+	}
+	switch strings.ToLower(w.ask(question, defaultValue)) {
+	case "y", "yes":
+		return true
+	case "n", "no":
+		return false
+	}
+	return defaultYes
+}
+
+// choose lists the options and returns the value of the one picked by its
+// number or value. Values not in the list are accepted when other is set.
+func (w *wizard) choose(question string, labels []string, values []string, defaultValue string, other bool) string {
+	for i, label := range labels {
+		fmt.Printf("  %2d) %v\n", i+1, label)
+	}
+	for {
+		answer := w.ask(question, defaultValue)
+		if n, err := strconv.Atoi(answer); err == nil && n >= 1 && n <= len(values) {
+			return values[n-1]
+		}
+		if answer != "" && (other || contains(values, answer)) {
+			return answer
+		}
+		fmt.Println("Pick one of the numbers or values above.")
+	}
+}
+
+// defaultPrivateKey is the first of the usual private keys that exists.
+func defaultPrivateKey() string {
+	home, _ := os.UserHomeDir()
+	for _, name := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+		path := filepath.Join(home, ".ssh", name)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return filepath.Join(home, ".ssh", "id_rsa")
+}
+
+// RunInitCommand asks for the token and lets the user pick the region, size
+// and SSH key of the account, then writes a validated config file readable
+// only by the user.
+func RunInitCommand(args []string) int {
+	flags := flag.NewFlagSet("init", flag.ExitOnError)
+	home, _ := os.UserHomeDir()
+	defaultPath := filepath.Join(home, ".config", configName, configName+".yml")
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		defaultPath = filepath.Join(dir, configName, configName+".yml")
+	}
+	path := flags.String("config", defaultPath, "Path of the configuration file to write")
+	flags.Parse(args)
+
+	w := &wizard{reader: bufio.NewReader(os.Stdin)}
+	if _, err := os.Stat(*path); err == nil && !w.confirm(fmt.Sprintf("%v exists. Overwrite it?", *path), false) {
+		fmt.Println("Aborted.")
+		return 1
+	}
+
+	conf := defaultConfig()
+	fmt.Println("A DigitalOcean token with read and write scopes is needed, see https://cloud.digitalocean.com/account/api/tokens.")
+	fmt.Println("Enter the token, or env:NAME, file:PATH or cmd:COMMAND to keep it out of the file.")
+	var account *godo.Account
+	var token string
+	for account == nil {
+		conf.DigitalOceanPat = w.askSecret("Token")
+		var err error
+		token, err = resolveSecret(conf.DigitalOceanPat)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		registerSecret(token)
+		InitDoClient(token)
+		if account, _, err = DoClient.Account.Get(context.TODO()); err != nil {
+			fmt.Printf("The token isn't accepted: %v\n", redact(err.Error()))
+		}
+	}
+	fmt.Printf("Logged in as %v.\n\n", account.Email)
+
+	regions, _, err := DoClient.Regions.List(context.TODO(), &godo.ListOptions{PerPage: 100})
+	if err != nil {
+		fmt.Printf("Error listing the regions: %v\n", err)
+		return 1
+	}
+	sort.Slice(regions, func(i, j int) bool { return regions[i].Slug < regions[j].Slug })
+	var labels, values []string
+	for _, region := range regions {
+		if region.Available {
+			labels = append(labels, fmt.Sprintf("%-6v %v", region.Slug, region.Name))
+			values = append(values, region.Slug)
+		}
+	}
+	fmt.Println("Regions:")
+	conf.Region = w.choose("Region", labels, values, "", false)
+	var region godo.Region
+	for _, candidate := range regions {
+		if candidate.Slug == conf.Region {
+			region = candidate
+		}
+	}
+
+	sizes, _, err := DoClient.Sizes.List(context.TODO(), &godo.ListOptions{PerPage: 200})
+	if err != nil {
+		fmt.Printf("Error listing the sizes: %v\n", err)
+		return 1
+	}
+	sort.Slice(sizes, func(i, j int) bool { return sizes[i].PriceHourly < sizes[j].PriceHourly })
+	labels, values = nil, nil
+	for _, size := range sizes {
+		if size.Available && contains(region.Sizes, size.Slug) && len(values) < initSizesShown {
+			labels = append(labels, fmt.Sprintf("%-22v %2d vCPU %6v RAM %4d GB disk  $%.3f/hour ($%.0f/month)",
+				size.Slug, size.Vcpus, formatBytes(int64(size.Memory)<<20), size.Disk, size.PriceHourly, size.PriceMonthly))
+			values = append(values, size.Slug)
+		}
+	}
+	fmt.Printf("\nSizes in %v, cheapest first (the disk has to hold the downloads):\n", conf.Region)
+	conf.Size = w.choose("Size", labels, values, conf.Size, true)
+
+	keys, _, err := DoClient.Keys.List(context.TODO(), &godo.ListOptions{PerPage: 200})
+	if err != nil {
+		fmt.Printf("Error listing the SSH keys: %v\n", err)
+		return 1
+	}
+	if len(keys) == 0 {
+		fmt.Println("\nThe account has no SSH keys. Add your public key at https://cloud.digitalocean.com/account/security and run init again.")
+		return 1
+	}
+	labels, values = nil, nil
+	for _, key := range keys {
+		labels = append(labels, fmt.Sprintf("%v (%v)", key.Name, key.Fingerprint))
+		values = append(values, key.Name)
+	}
+	fmt.Println("\nSSH keys:")
+	conf.SshKey = w.choose("SSH key", labels, values, "", false)
+
+	fmt.Println()
+	conf.SshPrivateKeyPath = w.ask("Private key of the SSH key", defaultPrivateKey())
+	conf.DownloadDir = w.ask("Local directory to download to", filepath.Join(home, "Downloads"))
+	if dir, err := filepath.Abs(conf.DownloadDir); err == nil {
+		conf.DownloadDir = dir
+	}
+
+	fmt.Println("\nChecking the configuration:")
+	passed := printPreflight(preflight(conf))
+	if !passed && !w.confirm("Write the configuration anyway?", false) {
+		fmt.Println("Aborted.")
+		return 1
+	}
+
+	if err := writeInitConfig(*path, conf); err != nil {
+		fmt.Printf("Error writing %v: %v\n", *path, err)
+		return 1
+	}
+	fmt.Printf("Wrote %v. See do-torrent-downloader.example.yml for the other settings.\n", *path)
+	return 0
+}
+
+// writeInitConfig writes the fields set by init to the file, readable only
+// by the user as it may contain the token.
+func writeInitConfig(path string, conf *config) error {
+	fields := yaml.MapSlice{
+		{Key: "digital_ocean_pat", Value: conf.DigitalOceanPat},
+		{Key: "region", Value: conf.Region},
+		{Key: "size", Value: conf.Size},
+		{Key: "image_slug", Value: conf.ImageSlug},
+		{Key: "droplet_name", Value: conf.DropletName},
+		{Key: "droplet_tag", Value: conf.DropletTag},
+		{Key: "ssh_key", Value: conf.SshKey},
+		{Key: "ssh_private_key_path", Value: conf.SshPrivateKeyPath},
+		{Key: "download_dir", Value: conf.DownloadDir},
+	}
+	data, err := yaml.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	data = append([]byte("# Written by do_torrent_downloader init.\n"), data...)
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		return err
+	}
+	// WriteFile keeps the mode of an existing file.
+	return os.Chmod(path, 0600)
+}
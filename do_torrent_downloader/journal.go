@@ -0,0 +1,173 @@
+package doTorrentDownloader
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// runJournal records what a run needs to be resumed later, e.g. with -ip
+// from another terminal. It is the only place the per-run WebUI password is
+// written to, so it is kept readable by the current user only.
+type runJournal struct {
+	RunID               string    `json:"run_id"`
+	DropletID           int       `json:"droplet_id"`
+	DropletIP           string    `json:"droplet_ip"`
+	StartedAt           time.Time `json:"started_at"`
+	QbittorrentPassword string    `json:"qbittorrent_password"`
+	MagnetLinks         []string  `json:"magnet_links,omitempty"`
+	// Destinations are the directories given with -m "<magnet>|<dir>", by
+	// info hash.
+	Destinations map[string]string `json:"destinations,omitempty"`
+	// Torrents are the torrents last seen on the droplet, so that a later
+	// pull places them the same way.
+	Torrents []journalTorrent `json:"torrents,omitempty"`
+	// LogFiles are the logs of the runs on the droplet.
+	LogFiles []string `json:"log_files,omitempty"`
+}
+
+// journalTorrent is what is kept of a torrent to place its files.
+type journalTorrent struct {
+	Hash     string `json:"hash"`
+	Name     string `json:"name"`
+	Category string `json:"category,omitempty"`
+}
+
+// stateDir is where run journals are kept. It follows the XDG base
+// directory spec and falls back to ~/.local/state.
+func stateDir() string {
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return filepath.Join(dir, "do-torrent-downloader")
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".local", "state", "do-torrent-downloader")
+}
+
+func journalPath(dropletID int) string {
+	return filepath.Join(stateDir(), "runs", fmt.Sprintf("%d.json", dropletID))
+}
+
+func newRunID() string {
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return fmt.Sprintf("%s-%s", time.Now().UTC().Format("20060102T150405"), hex.EncodeToString(suffix))
+}
+
+// NewJournal starts a journal for a fresh run on the given droplet.
+func NewJournal(dropletID int, dropletIP string) *runJournal {
+	return &runJournal{
+		RunID:     newRunID(),
+		DropletID: dropletID,
+		DropletIP: dropletIP,
+		StartedAt: time.Now(),
+	}
+}
+
+// LoadJournal reads the journal of the run on the given droplet.
+func LoadJournal(dropletID int) (*runJournal, error) {
+	return readJournal(journalPath(dropletID))
+}
+
+func readJournal(path string) (*runJournal, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var journal runJournal
+	if err := json.Unmarshal(data, &journal); err != nil {
+		return nil, fmt.Errorf("error reading journal %v: %v", path, err)
+	}
+	return &journal, nil
+}
+
+// LatestJournal returns the journal of the most recently started run.
+func LatestJournal() (*runJournal, error) {
+	paths, _ := filepath.Glob(filepath.Join(stateDir(), "runs", "*.json"))
+	var journals []*runJournal
+	for _, path := range paths {
+		if journal, err := readJournal(path); err == nil {
+			journals = append(journals, journal)
+		}
+	}
+	if len(journals) == 0 {
+		return nil, fmt.Errorf("no run journals found in %v", filepath.Join(stateDir(), "runs"))
+	}
+	sort.Slice(journals, func(i, j int) bool {
+		return journals[i].StartedAt.After(journals[j].StartedAt)
+	})
+	return journals[0], nil
+}
+
+// findJournal returns the journal of the run on the droplet with the given
+// IP, or the latest journal when ip is empty.
+func findJournal(ip string) (*runJournal, error) {
+	if ip == "" {
+		return LatestJournal()
+	}
+	paths, _ := filepath.Glob(filepath.Join(stateDir(), "runs", "*.json"))
+	for _, path := range paths {
+		if journal, err := readJournal(path); err == nil && journal.DropletIP == ip {
+			return journal, nil
+		}
+	}
+	return nil, fmt.Errorf("no run journal found for droplet %v", ip)
+}
+
+// RecordTorrents keeps the torrents seen on the droplet.
+func (journal *runJournal) RecordTorrents(torrents []Torrent) {
+	journal.Torrents = nil
+	for _, t := range torrents {
+		journal.Torrents = append(journal.Torrents, journalTorrent{Hash: t.Hash, Name: t.Name, Category: t.Category})
+	}
+}
+
+// KnownTorrents returns the recorded torrents.
+func (journal *runJournal) KnownTorrents() []Torrent {
+	var torrents []Torrent
+	for _, t := range journal.Torrents {
+		torrents = append(torrents, Torrent{Hash: t.Hash, Name: t.Name, Category: t.Category})
+	}
+	return torrents
+}
+
+func (journal *runJournal) Save() error {
+	path := journalPath(journal.DropletID)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(journal, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0600)
+}
+
+// DeleteJournal removes the journal once its droplet is gone.
+func DeleteJournal(dropletID int) {
+	os.Remove(journalPath(dropletID))
+}
+
+// RunPasswordCommand prints the WebUI address and password of the latest
+// run, or of the run on the droplet with the given IP.
+func RunPasswordCommand(args []string) int {
+	flags := flag.NewFlagSet("password", flag.ExitOnError)
+	ip := flags.String("ip", "", "Public IP of the droplet of the run")
+	flags.Parse(args)
+
+	journal, err := findJournal(*ip)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	fmt.Printf("WebUI: http://%v:8080\n", journal.DropletIP)
+	fmt.Printf("Username: admin\nPassword: %v\n", journal.QbittorrentPassword)
+	return 0
+}
@@ -0,0 +1,186 @@
+package doTorrentDownloader
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultLogKeep is the number of run logs kept by default.
+const defaultLogKeep = 30
+
+// logLevel is the severity of a log message.
+type logLevel int
+
+const (
+	levelDebug logLevel = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (level logLevel) String() string {
+	return levelNames[level]
+}
+
+// parseLogLevel parses debug, info, warn or error. Empty is info.
+func parseLogLevel(name string) (logLevel, error) {
+	if name == "" {
+		return levelInfo, nil
+	}
+	for level, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) {
+			return logLevel(level), nil
+		}
+	}
+	return levelInfo, fmt.Errorf("unknown log level %q, use debug, info, warn or error", name)
+}
+
+// runLogger writes leveled messages with key/value fields to stdout, or
+// stderr for warnings and errors, from its level on, and all of them to the
+// log file of the run. Secrets are redacted from both.
+type runLogger struct {
+	mu    sync.Mutex
+	level logLevel
+	file  *os.File
+	// fields are added to every line of the file, e.g. the run ID.
+	fields []interface{}
+}
+
+var logger = &runLogger{level: levelInfo}
+
+func logDebug(message string, fields ...interface{}) { logger.write(levelDebug, message, fields) }
+func logInfo(message string, fields ...interface{})  { logger.write(levelInfo, message, fields) }
+func logWarn(message string, fields ...interface{})  { logger.write(levelWarn, message, fields) }
+func logError(message string, fields ...interface{}) { logger.write(levelError, message, fields) }
+
+// logToFile writes a message to the log file only, for what is printed
+// otherwise.
+func logToFile(level logLevel, message string, fields ...interface{}) {
+	logger.mu.Lock()
+	defer logger.mu.Unlock()
+	logger.writeFile(level, message, fields)
+}
+
+func (l *runLogger) write(level logLevel, message string, fields []interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if level >= l.level {
+		prefix := ""
+		output := os.Stdout
+		switch level {
+		case levelDebug:
+			prefix = "[debug] "
+		case levelWarn:
+			prefix = "Warning: "
+			output = os.Stderr
+		case levelError:
+			output = os.Stderr
+		}
+		fmt.Fprintln(output, redact(prefix+message+formatFields(fields, false)))
+	}
+	l.writeFile(level, message, fields)
+}
+
+func (l *runLogger) writeFile(level logLevel, message string, fields []interface{}) {
+	if l.file != nil {
+		line := fmt.Sprintf("time=%v level=%v msg=%v", time.Now().UTC().Format(time.RFC3339Nano), level, quoteField(message))
+		line += formatFields(append(append([]interface{}{}, fields...), l.fields...), true)
+		fmt.Fprintln(l.file, redact(line))
+	}
+}
+
+// formatFields formats key/value pairs as " key=value", quoting the values
+// that need it in the file.
+func formatFields(fields []interface{}, quote bool) string {
+	var formatted strings.Builder
+	for i := 0; i+1 < len(fields); i += 2 {
+		value := fmt.Sprint(fields[i+1])
+		if quote {
+			value = quoteField(value)
+		}
+		fmt.Fprintf(&formatted, " %v=%v", fields[i], value)
+	}
+	return formatted.String()
+}
+
+func quoteField(value string) string {
+	if value == "" || strings.ContainsAny(value, " =\"\t\n") {
+		return strconv.Quote(value)
+	}
+	return value
+}
+
+// setLogLevel sets the level of the messages printed to stdout.
+func setLogLevel(level logLevel) {
+	logger.mu.Lock()
+	defer logger.mu.Unlock()
+	logger.level = level
+}
+
+// setLogRunID adds the run ID to the following lines of the log file.
+func setLogRunID(runID string) {
+	logger.mu.Lock()
+	defer logger.mu.Unlock()
+	logger.fields = []interface{}{"run_id", runID}
+}
+
+// logDir is where the run logs are written, log.dir or the logs directory
+// of the state directory.
+func logDir(conf *config) string {
+	if conf.Log.Dir != "" {
+		return conf.Log.Dir
+	}
+	return filepath.Join(stateDir(), "logs")
+}
+
+// openRunLog starts the log file of a run of the mode, readable only by the
+// user, and deletes the oldest logs beyond log.keep.
+func openRunLog(conf *config, mode string) (string, error) {
+	dir := logDir(conf)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, fmt.Sprintf("%s-%s.log", time.Now().UTC().Format("20060102T150405.000"), mode))
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return "", err
+	}
+	logger.mu.Lock()
+	logger.file = file
+	logger.mu.Unlock()
+
+	if conf.Log.Keep > 0 {
+		pruneRunLogs(dir, conf.Log.Keep)
+	}
+	return path, nil
+}
+
+// pruneRunLogs deletes all but the newest keep logs. Their names start
+// with the time, so they sort by age.
+func pruneRunLogs(dir string, keep int) {
+	logs, _ := filepath.Glob(filepath.Join(dir, "*.log"))
+	sort.Strings(logs)
+	for len(logs) > keep {
+		os.Remove(logs[0])
+		logs = logs[1:]
+	}
+}
+
+// closeRunLog closes the log file of the run.
+func closeRunLog() {
+	logger.mu.Lock()
+	defer logger.mu.Unlock()
+	if logger.file != nil {
+		logger.file.Close()
+		logger.file = nil
+	}
+}
@@ -0,0 +1,454 @@
+package doTorrentDownloader
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/digitalocean/godo"
+)
+
+// Events notified to the channels of notify.channels.
+const (
+	notifyCompleted      = "completed"
+	notifyTransferred    = "transferred"
+	notifyFailed         = "failed"
+	notifyDropletRunning = "droplet_running"
+	notifyBudgetExceeded = "budget_exceeded"
+)
+
+// notifyTimeout bounds the delivery to a channel.
+const notifyTimeout = 30 * time.Second
+
+// budgetInterval is how often the cost is checked against notify.budget.
+const budgetInterval = time.Minute
+
+// notifyTemplate is the title and message of an event, as text/template.
+type notifyTemplate struct {
+	Title   string `yaml:"title"`
+	Message string `yaml:"message"`
+}
+
+// defaultNotifyTemplates are the templates of the events unless a channel
+// sets its own.
+var defaultNotifyTemplates = map[string]notifyTemplate{
+	notifyCompleted: {
+		Title:   "Downloads complete",
+		Message: "{{len .Torrents}} torrent(s), {{bytes .Size}}, completed on {{.Droplet}} in {{duration .Duration}}. Cost so far {{usd .Cost}}.\n{{range .Torrents}}- {{.Name}} ({{bytes .Size}})\n{{end}}",
+	},
+	notifyTransferred: {
+		Title:   "Transfer done",
+		Message: "{{len .Torrents}} torrent(s), {{bytes .Size}}, transferred in {{duration .Duration}}. Cost {{usd .Cost}}.\n{{range .Torrents}}- {{.Name}} ({{bytes .Size}})\n{{end}}",
+	},
+	notifyFailed: {
+		Title:   "Run failed",
+		Message: "The run failed after {{duration .Duration}}{{if .Error}}: {{.Error}}{{end}}. Cost {{usd .Cost}}.",
+	},
+	notifyDropletRunning: {
+		Title:   "Droplet left running",
+		Message: "{{.Droplet}} ({{.DropletIP}}) keeps running at {{usd .HourlyPrice}}/hour, {{usd .Cost}} so far. Transfer with `do_torrent_downloader pull -ip {{.DropletIP}}` or delete it with `do_torrent_downloader destroy {{.DropletIP}}`.",
+	},
+	notifyBudgetExceeded: {
+		Title:   "Budget exceeded",
+		Message: "{{.Droplet}} ({{.DropletIP}}) has cost {{usd .Cost}} in {{duration .Duration}}, over the budget of {{usd .Budget}}.",
+	},
+}
+
+var notifyFuncs = template.FuncMap{
+	"bytes": formatBytes,
+	"usd":   func(amount float64) string { return fmt.Sprintf("$%.2f", amount) },
+	"duration": func(seconds float64) string {
+		return formatDuration(time.Duration(seconds * float64(time.Second)).Round(time.Minute))
+	},
+}
+
+// notifiedTorrent is a torrent of a notification.
+type notifiedTorrent struct {
+	Name string `json:"name"`
+	Hash string `json:"hash,omitempty"`
+	Size int64  `json:"size"`
+}
+
+// notification is the data of the templates and the body of webhooks.
+// Duration is in seconds since the run started, Cost in USD since the
+// droplet was created.
+type notification struct {
+	Event       string            `json:"event"`
+	Title       string            `json:"title"`
+	Message     string            `json:"message"`
+	RunID       string            `json:"run_id,omitempty"`
+	Droplet     string            `json:"droplet,omitempty"`
+	DropletIP   string            `json:"droplet_ip,omitempty"`
+	Torrents    []notifiedTorrent `json:"torrents,omitempty"`
+	Size        int64             `json:"size"`
+	Duration    float64           `json:"duration"`
+	Cost        float64           `json:"cost"`
+	HourlyPrice float64           `json:"hourly_price"`
+	Budget      float64           `json:"budget,omitempty"`
+	Error       string            `json:"error,omitempty"`
+}
+
+// notifySender delivers notifications to a channel.
+type notifySender interface {
+	Send(ctx context.Context, n notification) error
+}
+
+// notifyChannel is a configured channel with its parsed templates.
+type notifyChannel struct {
+	name      string
+	events    map[string]bool
+	templates map[string][2]*template.Template
+	sender    notifySender
+}
+
+// notifier notifies the channels of the events of a run. A nil notifier
+// notifies nothing.
+type notifier struct {
+	channels []notifyChannel
+	budget   float64
+	started  time.Time
+
+	mu          sync.Mutex
+	runID       string
+	droplet     *godo.Droplet
+	created     time.Time
+	hourlyPrice float64
+	sent        map[string]bool
+}
+
+// lastFatalError is the error that ended the run, for the failure
+// notification.
+var lastFatalError string
+
+// newNotifier parses notify.channels. It returns nil without channels.
+func newNotifier(conf *config) (*notifier, error) {
+	if conf.Notify.Budget < 0 {
+		return nil, fmt.Errorf("invalid notify budget %v", conf.Notify.Budget)
+	}
+	if len(conf.Notify.Channels) == 0 {
+		return nil, nil
+	}
+	n := &notifier{budget: conf.Notify.Budget, started: time.Now(), sent: map[string]bool{}}
+	for i, channelConf := range conf.Notify.Channels {
+		channel, err := newNotifyChannel(channelConf)
+		if err != nil {
+			return nil, fmt.Errorf("notify channel %d (%v): %v", i+1, channelConf.Type, err)
+		}
+		n.channels = append(n.channels, channel)
+	}
+	return n, nil
+}
+
+func newNotifyChannel(conf notifyChannelConfig) (notifyChannel, error) {
+	channel := notifyChannel{name: conf.Type, events: map[string]bool{}, templates: map[string][2]*template.Template{}}
+	switch conf.Type {
+	case "webhook":
+		if conf.URL == "" {
+			return channel, fmt.Errorf("url is required")
+		}
+		channel.sender = &webhookSender{url: conf.URL, token: conf.Token, headers: conf.Headers}
+	case "ntfy":
+		if conf.URL == "" {
+			return channel, fmt.Errorf("url is required, e.g. https://ntfy.sh/<topic>")
+		}
+		channel.sender = &ntfySender{url: conf.URL, token: conf.Token}
+	case "email":
+		if conf.Host == "" || conf.From == "" || len(conf.To) == 0 {
+			return channel, fmt.Errorf("host, from and to are required")
+		}
+		if _, _, err := net.SplitHostPort(conf.Host); err != nil {
+			return channel, fmt.Errorf("host %q isn't host:port, e.g. smtp.example.com:587", conf.Host)
+		}
+		channel.sender = &emailSender{host: conf.Host, username: conf.Username, password: conf.Password, from: conf.From, to: conf.To}
+	default:
+		return channel, fmt.Errorf("unknown type %q, use webhook, ntfy or email", conf.Type)
+	}
+
+	events := conf.Events
+	if len(events) == 0 {
+		events = []string{notifyCompleted, notifyTransferred, notifyFailed, notifyDropletRunning, notifyBudgetExceeded}
+	}
+	for _, event := range events {
+		if _, ok := defaultNotifyTemplates[event]; !ok {
+			return channel, fmt.Errorf("unknown event %q, use completed, transferred, failed, droplet_running or budget_exceeded", event)
+		}
+		channel.events[event] = true
+	}
+	for event := range conf.Templates {
+		if _, ok := defaultNotifyTemplates[event]; !ok {
+			return channel, fmt.Errorf("template of unknown event %q", event)
+		}
+	}
+
+	for event, defaults := range defaultNotifyTemplates {
+		texts := [2]string{defaults.Title, defaults.Message}
+		if custom, ok := conf.Templates[event]; ok {
+			if custom.Title != "" {
+				texts[0] = custom.Title
+			}
+			if custom.Message != "" {
+				texts[1] = custom.Message
+			}
+		}
+		var parsed [2]*template.Template
+		for i, text := range texts {
+			tmpl, err := template.New(event).Funcs(notifyFuncs).Parse(text)
+			if err != nil {
+				return channel, fmt.Errorf("template of %v: %v", event, err)
+			}
+			parsed[i] = tmpl
+		}
+		channel.templates[event] = parsed
+	}
+	return channel, nil
+}
+
+// SetDroplet sets the droplet and run of the following notifications.
+func (n *notifier) SetDroplet(droplet *godo.Droplet, hourlyPrice float64, runID string) {
+	if n == nil {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.droplet, n.hourlyPrice, n.runID = droplet, hourlyPrice, runID
+	n.created, _ = time.Parse(time.RFC3339, droplet.Created)
+}
+
+// cost is what the droplet has cost so far. It is called with the lock held.
+func (n *notifier) cost() float64 {
+	if n.created.IsZero() {
+		return 0
+	}
+	return n.hourlyPrice * time.Since(n.created).Hours()
+}
+
+// Notify sends the event to the channels that take it and waits for the
+// delivery. Failures are logged.
+func (n *notifier) Notify(event string, torrents []Torrent) {
+	if n == nil {
+		return
+	}
+	n.mu.Lock()
+	data := notification{
+		Event:       event,
+		RunID:       n.runID,
+		Duration:    time.Since(n.started).Seconds(),
+		Cost:        n.cost(),
+		HourlyPrice: n.hourlyPrice,
+		Budget:      n.budget,
+	}
+	if n.droplet != nil {
+		data.Droplet = n.droplet.Name
+		data.DropletIP, _ = n.droplet.PublicIPv4()
+	}
+	n.mu.Unlock()
+	if event == notifyFailed {
+		data.Error = lastFatalError
+	}
+	for _, t := range torrents {
+		data.Torrents = append(data.Torrents, notifiedTorrent{Name: t.Name, Hash: t.Hash, Size: t.Size})
+		data.Size += t.Size
+	}
+
+	var wg sync.WaitGroup
+	for _, channel := range n.channels {
+		if !channel.events[event] {
+			continue
+		}
+		message := data
+		var texts [2]bytes.Buffer
+		for i, tmpl := range channel.templates[event] {
+			if err := tmpl.Execute(&texts[i], data); err != nil {
+				logWarn("Error rendering the notification", "channel", channel.name, "event", event, "error", err)
+			}
+		}
+		message.Title = "do-torrent-downloader: " + strings.TrimSpace(texts[0].String())
+		message.Message = strings.TrimSpace(texts[1].String())
+
+		wg.Add(1)
+		go func(channel notifyChannel) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+			defer cancel()
+			if err := channel.sender.Send(ctx, message); err != nil {
+				logWarn("Error sending the notification", "channel", channel.name, "event", event, "error", err)
+			} else {
+				logDebug("Notification sent", "channel", channel.name, "event", event)
+			}
+		}(channel)
+	}
+	wg.Wait()
+}
+
+// WatchBudget notifies budget_exceeded once when the droplet's cost passes
+// notify.budget, until the returned function is called.
+func (n *notifier) WatchBudget() func() {
+	if n == nil || n.budget == 0 {
+		return func() {}
+	}
+	stop := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(budgetInterval)
+		defer ticker.Stop()
+		for {
+			n.mu.Lock()
+			exceeded := !n.sent[notifyBudgetExceeded] && n.cost() > n.budget
+			if exceeded {
+				n.sent[notifyBudgetExceeded] = true
+			}
+			n.mu.Unlock()
+			if exceeded {
+				logWarn("The droplet is over the budget", "budget", n.budget)
+				n.Notify(notifyBudgetExceeded, nil)
+			}
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return func() { close(stop) }
+}
+
+// webhookSender posts the notification as JSON.
+type webhookSender struct {
+	url     string
+	token   string
+	headers map[string]string
+}
+
+func (sender *webhookSender) Send(ctx context.Context, n notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, sender.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	for name, value := range sender.headers {
+		request.Header.Set(name, value)
+	}
+	if sender.token != "" {
+		request.Header.Set("Authorization", "Bearer "+sender.token)
+	}
+	return doNotifyRequest(request)
+}
+
+// ntfySender publishes the notification to an ntfy topic URL.
+type ntfySender struct {
+	url   string
+	token string
+}
+
+func (sender *ntfySender) Send(ctx context.Context, n notification) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, sender.url, strings.NewReader(n.Message))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Title", n.Title)
+	switch n.Event {
+	case notifyFailed, notifyBudgetExceeded, notifyDropletRunning:
+		request.Header.Set("Priority", "high")
+		request.Header.Set("Tags", "warning")
+	default:
+		request.Header.Set("Tags", "white_check_mark")
+	}
+	if sender.token != "" {
+		request.Header.Set("Authorization", "Bearer "+sender.token)
+	}
+	return doNotifyRequest(request)
+}
+
+func doNotifyRequest(request *http.Request) error {
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= 300 {
+		return fmt.Errorf("%v answered %v", request.URL.Host, response.Status)
+	}
+	return nil
+}
+
+// emailSender sends the notification by SMTP, with STARTTLS when the
+// server offers it or TLS on port 465.
+type emailSender struct {
+	host     string
+	username string
+	password string
+	from     string
+	to       []string
+}
+
+func (sender *emailSender) Send(ctx context.Context, n notification) error {
+	hostname, port, _ := net.SplitHostPort(sender.host)
+	var message bytes.Buffer
+	fmt.Fprintf(&message, "From: %v\r\nTo: %v\r\nSubject: %v\r\nDate: %v\r\n", sender.from, strings.Join(sender.to, ", "), n.Title, time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&message, "MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	message.WriteString(strings.ReplaceAll(n.Message, "\n", "\r\n"))
+	message.WriteString("\r\n")
+
+	dialer := &net.Dialer{}
+	var conn net.Conn
+	var err error
+	if port == "465" {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: hostname}}).DialContext(ctx, "tcp", sender.host)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", sender.host)
+	}
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	client, err := smtp.NewClient(conn, hostname)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok && port != "465" {
+		if err := client.StartTLS(&tls.Config{ServerName: hostname}); err != nil {
+			return err
+		}
+	}
+	if sender.username != "" {
+		if err := client.Auth(smtp.PlainAuth("", sender.username, sender.password, hostname)); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(sender.from); err != nil {
+		return err
+	}
+	for _, to := range sender.to {
+		if err := client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := writer.Write(message.Bytes()); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
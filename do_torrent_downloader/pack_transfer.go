@@ -0,0 +1,310 @@
+package doTorrentDownloader
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultPackThreshold is the number of files per GiB above which a job is
+// packed, i.e. files of 1 MiB on average.
+const defaultPackThreshold = 1024
+
+// remoteFile is a file of a job as listed on the droplet, with the path
+// relative to the job's parent directory.
+type remoteFile struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+// packTransferer streams the files of jobs with many small files as a single
+// compressed tar archive over SSH and unpacks it on the fly, which avoids the
+// per-file round trips of the other engines. Other jobs are handed to the
+// wrapped transferer.
+type packTransferer struct {
+	conf        *config
+	sshClient   SshClientOp
+	control     *transferControl
+	next        Transferer
+	compression string
+}
+
+// newPackTransferer wraps the transferer unless packing is turned off.
+func newPackTransferer(conf *config, sshClient SshClientOp, control *transferControl, next Transferer) (Transferer, error) {
+	switch conf.Transfer.Pack {
+	case "", "auto", "always":
+	case "never":
+		return next, nil
+	default:
+		return nil, fmt.Errorf("unknown pack mode %q, use auto, always or never", conf.Transfer.Pack)
+	}
+
+	compression := conf.Transfer.PackCompression
+	switch compression {
+	case "":
+		compression = "gzip"
+		if packCompressionAvailable(sshClient, "zstd") {
+			compression = "zstd"
+		}
+	case "zstd":
+		// Failing here would lose the droplet's finished downloads.
+		if !packCompressionAvailable(sshClient, "zstd") {
+			logWarn("zstd isn't installed on the droplet, packing with gzip")
+			compression = "gzip"
+		}
+	case "gzip", "none":
+	default:
+		return nil, fmt.Errorf("unknown pack compression %q, use zstd, gzip or none", compression)
+	}
+	return &packTransferer{conf: conf, sshClient: sshClient, control: control, next: next, compression: compression}, nil
+}
+
+// packCompressionAvailable tells whether the compressor is installed on both
+// ends.
+func packCompressionAvailable(sshClient SshClientOp, name string) bool {
+	if _, err := exec.LookPath(name); err != nil {
+		return false
+	}
+	return strings.TrimSpace(sshClient.executeCmd("command -v "+name+" >/dev/null && echo yes")) == "yes"
+}
+
+func (transferer *packTransferer) Name() string {
+	return fmt.Sprintf("%v, packing small files as %v tar streams", transferer.next.Name(), transferer.compression)
+}
+
+func (transferer *packTransferer) Transfer(jobs []TransferJob, progress func(TransferProgress)) error {
+	var others []TransferJob
+	var failed []string
+	for _, job := range jobs {
+		files, err := listRemoteFiles(transferer.sshClient, job)
+		if err != nil || !transferer.shouldPack(files) {
+			others = append(others, job)
+			continue
+		}
+		if err := transferer.transferPacked(job, files, progress); err != nil {
+			failed = append(failed, fmt.Sprintf("%v: %v", job.Name, err))
+		}
+	}
+	if len(others) > 0 {
+		if err := transferer.next.Transfer(others, progress); err != nil {
+			failed = append(failed, err.Error())
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("transfer failed for %v", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// shouldPack decides by the number of files per GiB of the job.
+func (transferer *packTransferer) shouldPack(files []remoteFile) bool {
+	if transferer.conf.Transfer.Pack == "always" {
+		return len(files) > 0
+	}
+	if len(files) < 2 {
+		return false
+	}
+	var size int64
+	for _, file := range files {
+		size += file.size
+	}
+	threshold := transferer.conf.Transfer.PackThreshold
+	if threshold <= 0 {
+		threshold = defaultPackThreshold
+	}
+	return float64(len(files))/float64(max64(size, 1))*(1<<30) >= float64(threshold)
+}
+
+// listRemoteFiles lists the regular files of the job on the droplet.
+func listRemoteFiles(sshClient SshClientOp, job TransferJob) ([]remoteFile, error) {
+	output := sshClient.executeCmd(fmt.Sprintf(
+		"cd %s && find %s -type f -printf '%%s %%T@ %%p\\0'",
+		shellQuote(path.Dir(job.RemotePath)), shellQuote(path.Base(job.RemotePath)),
+	))
+
+	var files []remoteFile
+	for _, entry := range strings.Split(output, "\x00") {
+		parts := strings.SplitN(entry, " ", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		size, err := strconv.ParseInt(parts[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		seconds, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil {
+			continue
+		}
+		files = append(files, remoteFile{name: parts[2], size: size, modTime: time.Unix(int64(seconds), 0)})
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no files found in %v", job.RemotePath)
+	}
+	return files, nil
+}
+
+// transferPacked streams the files that aren't complete locally. A file is
+// only given the remote modification time once it is fully written, so an
+// interrupted stream resumes with the file it stopped at.
+func (transferer *packTransferer) transferPacked(job TransferJob, files []remoteFile, progress func(TransferProgress)) error {
+	state := TransferProgress{Job: job.Name}
+	var pending []string
+	localPaths := map[string]string{}
+	for _, file := range files {
+		localPath, ok := job.Rules.Place(job, file.name, file.size)
+		if !ok {
+			continue
+		}
+		state.BytesTotal += file.size
+		state.FilesTotal++
+		if info, err := os.Stat(localPath); err == nil && info.Size() == file.size && info.ModTime().Unix() == file.modTime.Unix() {
+			state.BytesDone += file.size
+			state.FilesDone++
+			continue
+		}
+		pending = append(pending, file.name)
+		localPaths[file.name] = localPath
+	}
+	report := func() {
+		if progress != nil {
+			progress(state)
+		}
+	}
+	report()
+	if len(pending) == 0 {
+		return nil
+	}
+
+	conn, err := transferer.sshClient.Dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	session, err := conn.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	compressor := map[string]string{"zstd": " | zstd -q -c -T0", "gzip": " | gzip -c -1", "none": ""}[transferer.compression]
+	session.Stdin = strings.NewReader(strings.Join(pending, "\x00") + "\x00")
+	session.Stderr = os.Stderr
+	stream, err := session.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	command := fmt.Sprintf("cd %s && tar --null -T - -cf -%s", shellQuote(path.Dir(job.RemotePath)), compressor)
+	if err := session.Start(command); err != nil {
+		return err
+	}
+
+	archive, closeArchive, err := transferer.decompress(transferer.control.reader(stream))
+	if err != nil {
+		return err
+	}
+	err = unpackFiles(tar.NewReader(archive), localPaths, transferer.control, func(n int64, fileDone bool) {
+		state.BytesDone += n
+		state.BytesTransferred += n
+		if fileDone {
+			state.FilesDone++
+		}
+		report()
+	})
+	if err != nil {
+		// The remote tar and the local zstd block writing to pipes that are
+		// no longer read, so the connection is closed to end the stream
+		// before they are waited for.
+		conn.Close()
+		closeArchive(true)
+		session.Wait()
+		return err
+	}
+	err = closeArchive(false)
+	if waitErr := session.Wait(); err == nil {
+		err = waitErr
+	}
+	return err
+}
+
+// decompress returns the tar stream of the compressed stream and a function
+// that ends it, killing the decompressor when the stream is aborted. zstd
+// is decompressed by the local zstd binary.
+func (transferer *packTransferer) decompress(stream io.Reader) (io.Reader, func(abort bool) error, error) {
+	switch transferer.compression {
+	case "gzip":
+		reader, err := gzip.NewReader(stream)
+		if err != nil {
+			return nil, nil, err
+		}
+		return reader, func(bool) error { return reader.Close() }, nil
+	case "zstd":
+		cmd := exec.Command("zstd", "-q", "-d", "-c")
+		cmd.Stdin = stream
+		cmd.Stderr = os.Stderr
+		output, err := cmd.StdoutPipe()
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := cmd.Start(); err != nil {
+			return nil, nil, err
+		}
+		return output, func(abort bool) error {
+			if abort {
+				cmd.Process.Kill()
+			}
+			return cmd.Wait()
+		}, nil
+	}
+	return stream, func(bool) error { return nil }, nil
+}
+
+// unpackFiles writes the regular files of the archive to their local paths,
+// by their names in the archive. Files that weren't asked for are skipped.
+// report gets the bytes written and is told when a file is complete.
+func unpackFiles(archive *tar.Reader, localPaths map[string]string, control *transferControl, report func(int64, bool)) error {
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		localPath, ok := localPaths[header.Name]
+		if !ok {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+			return err
+		}
+		local, err := os.OpenFile(localPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+		if err != nil {
+			return err
+		}
+		writer := control.writer(local, filepath.Dir(localPath), false)
+		_, err = io.Copy(&progressWriter{writer: writer, report: func(n int64) { report(n, false) }}, archive)
+		if closeErr := local.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+		if err := os.Chtimes(localPath, header.ModTime, header.ModTime); err != nil {
+			return err
+		}
+		report(0, true)
+	}
+}
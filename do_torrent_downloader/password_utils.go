@@ -9,6 +9,31 @@ import (
 	"golang.org/x/crypto/pbkdf2"
 )
 
+// Only alphanumerics so the password can be passed in form data and shell
+// commands without any escaping.
+const passwordAlphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// generateRandomPassword returns a random password of the given length
+// using crypto/rand.
+func generateRandomPassword(length int) (string, error) {
+	password := make([]byte, 0, length)
+	random := make([]byte, length)
+	for len(password) < length {
+		if _, err := rand.Read(random); err != nil {
+			return "", err
+		}
+		for _, b := range random {
+			// Skip bytes past the largest multiple of the alphabet size to
+			// avoid a modulo bias.
+			if int(b) >= 256-256%len(passwordAlphabet) || len(password) == length {
+				continue
+			}
+			password = append(password, passwordAlphabet[int(b)%len(passwordAlphabet)])
+		}
+	}
+	return string(password), nil
+}
+
 func generateQbittorrentHash(password string) (string, error) {
 	// 1. Generate a random salt (16 bytes)
 	salt := make([]byte, 16)
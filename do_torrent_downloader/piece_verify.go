@@ -0,0 +1,509 @@
+package doTorrentDownloader
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const v2BlockSize = 16 * 1024
+
+// torrentMeta is the part of a .torrent file needed to verify its data.
+type torrentMeta struct {
+	Name        string
+	PieceLength int64
+	// Pieces are the SHA-1 piece hashes of v1 and hybrid torrents.
+	Pieces string
+	// Files are in the order their data is laid out in v1 pieces. Single
+	// file torrents have one file with an empty path.
+	Files []torrentFile
+	// PieceLayers maps a v2 file's pieces root to its SHA-256 piece hashes.
+	PieceLayers map[string]string
+	IsV2        bool
+}
+
+type torrentFile struct {
+	Path       string // slash separated and relative to the torrent's name
+	Length     int64
+	Padding    bool
+	PiecesRoot string
+}
+
+// pieceReport is the outcome of verifying a torrent's local data.
+type pieceReport struct {
+	Verified int
+	Skipped  int
+	Failed   int
+	// BadFiles are the files touched by failed pieces.
+	BadFiles []string
+}
+
+func parseTorrentMeta(data []byte) (*torrentMeta, error) {
+	decoded, err := bdecode(data)
+	if err != nil {
+		return nil, err
+	}
+	root, ok := decoded.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("torrent is not a dictionary")
+	}
+	info, ok := root["info"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("torrent has no info dictionary")
+	}
+
+	meta := &torrentMeta{PieceLayers: map[string]string{}}
+	meta.Name, _ = info["name"].(string)
+	meta.PieceLength, _ = info["piece length"].(int64)
+	meta.Pieces, _ = info["pieces"].(string)
+	if meta.Name == "" || meta.PieceLength <= 0 {
+		return nil, fmt.Errorf("torrent has no name or piece length")
+	}
+
+	if version, _ := info["meta version"].(int64); version == 2 {
+		meta.IsV2 = true
+		if layers, ok := root["piece layers"].(map[string]interface{}); ok {
+			for key, value := range layers {
+				meta.PieceLayers[key], _ = value.(string)
+			}
+		}
+	}
+
+	switch {
+	case info["files"] != nil:
+		files, _ := info["files"].([]interface{})
+		for _, f := range files {
+			file, _ := f.(map[string]interface{})
+			length, _ := file["length"].(int64)
+			attr, _ := file["attr"].(string)
+			var parts []string
+			pathList, _ := file["path"].([]interface{})
+			for _, part := range pathList {
+				partString, _ := part.(string)
+				parts = append(parts, partString)
+			}
+			meta.Files = append(meta.Files, torrentFile{
+				Path:    strings.Join(parts, "/"),
+				Length:  length,
+				Padding: strings.Contains(attr, "p"),
+			})
+		}
+	case info["length"] != nil:
+		length, _ := info["length"].(int64)
+		meta.Files = []torrentFile{{Length: length}}
+	case info["file tree"] != nil:
+		// v2 only torrents only have the file tree, in which files are
+		// ordered by path.
+		tree, _ := info["file tree"].(map[string]interface{})
+		meta.Files = flattenFileTree(tree, "")
+		if len(meta.Files) == 1 && meta.Files[0].Path == meta.Name {
+			meta.Files[0].Path = ""
+		}
+	default:
+		return nil, fmt.Errorf("torrent has no files")
+	}
+
+	// Hybrid torrents carry both the v1 file list and the v2 file tree.
+	if meta.IsV2 && info["file tree"] != nil {
+		tree, _ := info["file tree"].(map[string]interface{})
+		roots := map[string]string{}
+		for _, file := range flattenFileTree(tree, "") {
+			roots[file.Path] = file.PiecesRoot
+		}
+		for i := range meta.Files {
+			if root, ok := roots[meta.Files[i].Path]; ok {
+				meta.Files[i].PiecesRoot = root
+			} else if root, ok := roots[meta.Name]; ok && meta.Files[i].Path == "" {
+				meta.Files[i].PiecesRoot = root
+			}
+		}
+	}
+	return meta, nil
+}
+
+func flattenFileTree(tree map[string]interface{}, prefix string) []torrentFile {
+	names := make([]string, 0, len(tree))
+	for name := range tree {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var files []torrentFile
+	for _, name := range names {
+		node, _ := tree[name].(map[string]interface{})
+		if leaf, ok := node[""].(map[string]interface{}); ok {
+			length, _ := leaf["length"].(int64)
+			root, _ := leaf["pieces root"].(string)
+			files = append(files, torrentFile{Path: path.Join(prefix, name), Length: length, PiecesRoot: root})
+			continue
+		}
+		files = append(files, flattenFileTree(node, path.Join(prefix, name))...)
+	}
+	return files
+}
+
+// fileLocator returns where a file of the torrent is found locally, or false
+// when it wasn't transferred.
+type fileLocator func(file torrentFile) (string, bool)
+
+// localPath returns where the file is found below dir.
+func (meta *torrentMeta) localPath(dir string, file torrentFile) string {
+	return filepath.Join(dir, meta.Name, filepath.FromSlash(file.Path))
+}
+
+// inDir locates the files below dir.
+func (meta *torrentMeta) inDir(dir string) fileLocator {
+	return func(file torrentFile) (string, bool) {
+		return meta.localPath(dir, file), true
+	}
+}
+
+// verifyPieces checks the local data of the torrent against its piece
+// hashes. Pieces that touch files in unwanted, whose paths are relative to
+// the torrent's name, or files that weren't transferred can't be verified
+// and are skipped. v2 hashes are preferred as they don't span files.
+func verifyPieces(meta *torrentMeta, locate fileLocator, unwanted map[string]bool) (*pieceReport, error) {
+	if meta.IsV2 && meta.hasV2Hashes() {
+		return verifyPiecesV2(meta, locate, unwanted)
+	}
+	if len(meta.Pieces) == 0 || len(meta.Pieces)%sha1.Size != 0 {
+		return nil, fmt.Errorf("torrent has no usable piece hashes")
+	}
+	return verifyPiecesV1(meta, locate, unwanted)
+}
+
+func (meta *torrentMeta) hasV2Hashes() bool {
+	for _, file := range meta.Files {
+		if !file.Padding && file.Length > 0 && file.PiecesRoot == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// verifyPiecesV1 reads the files as one stream split into pieces, so a piece
+// may span the end of one file and the start of the next.
+func verifyPiecesV1(meta *torrentMeta, locate fileLocator, unwanted map[string]bool) (*pieceReport, error) {
+	type span struct {
+		file   torrentFile
+		offset int64 // of the file in the stream
+	}
+	var spans []span
+	var total int64
+	for _, file := range meta.Files {
+		spans = append(spans, span{file: file, offset: total})
+		total += file.Length
+	}
+
+	handles := map[string]*os.File{}
+	defer func() {
+		for _, handle := range handles {
+			handle.Close()
+		}
+	}()
+
+	report := &pieceReport{}
+	badFiles := map[string]bool{}
+	pieceCount := len(meta.Pieces) / sha1.Size
+	buffer := make([]byte, meta.PieceLength)
+	for piece := 0; piece < pieceCount; piece++ {
+		start := int64(piece) * meta.PieceLength
+		end := start + meta.PieceLength
+		if end > total {
+			end = total
+		}
+		data := buffer[:end-start]
+
+		skip := false
+		var touched []string
+		for _, s := range spans {
+			fileStart, fileEnd := s.offset, s.offset+s.file.Length
+			if fileEnd <= start || fileStart >= end || s.file.Length == 0 {
+				continue
+			}
+			from, to := max64(start, fileStart), min64(end, fileEnd)
+			chunk := data[from-start : to-start]
+			if s.file.Padding {
+				// Padding files are all zeros and never stored.
+				for i := range chunk {
+					chunk[i] = 0
+				}
+				continue
+			}
+			localPath, transferred := locate(s.file)
+			if unwanted[s.file.Path] || !transferred {
+				skip = true
+				break
+			}
+			touched = append(touched, s.file.Path)
+
+			handle, ok := handles[s.file.Path]
+			if !ok {
+				var err error
+				handle, err = os.Open(localPath)
+				if err != nil {
+					return nil, fmt.Errorf("missing file %v: %v", s.file.Path, err)
+				}
+				handles[s.file.Path] = handle
+			}
+			if _, err := handle.ReadAt(chunk, from-fileStart); err != nil && err != io.EOF {
+				return nil, err
+			} else if err == io.EOF {
+				// A short file can't match; hash what was read.
+				badFiles[s.file.Path] = true
+			}
+		}
+		if skip {
+			report.Skipped++
+			continue
+		}
+
+		sum := sha1.Sum(data)
+		if string(sum[:]) == meta.Pieces[piece*sha1.Size:(piece+1)*sha1.Size] {
+			report.Verified++
+			continue
+		}
+		report.Failed++
+		for _, name := range touched {
+			badFiles[name] = true
+		}
+	}
+
+	for name := range badFiles {
+		report.BadFiles = append(report.BadFiles, name)
+	}
+	sort.Strings(report.BadFiles)
+	return report, nil
+}
+
+// verifyPiecesV2 checks every file on its own against the merkle roots of
+// its pieces. Files no larger than a piece are checked against their pieces
+// root directly.
+func verifyPiecesV2(meta *torrentMeta, locate fileLocator, unwanted map[string]bool) (*pieceReport, error) {
+	report := &pieceReport{}
+	leavesPerPiece := int(meta.PieceLength / v2BlockSize)
+	buffer := make([]byte, meta.PieceLength)
+
+	for _, file := range meta.Files {
+		if file.Padding || file.Length == 0 {
+			continue
+		}
+		pieces := int((file.Length + meta.PieceLength - 1) / meta.PieceLength)
+		localPath, transferred := locate(file)
+		if unwanted[file.Path] || !transferred {
+			report.Skipped += pieces
+			continue
+		}
+
+		handle, err := os.Open(localPath)
+		if err != nil {
+			return nil, fmt.Errorf("missing file %v: %v", file.Path, err)
+		}
+
+		failed := 0
+		if file.Length <= meta.PieceLength {
+			data := buffer[:file.Length]
+			_, err = io.ReadFull(handle, data)
+			leaves := blockHashes(data)
+			if err != nil || merkleRoot(leaves, nextPowerOfTwo(len(leaves))) != file.PiecesRoot {
+				failed = 1
+			}
+		} else {
+			layer := meta.PieceLayers[file.PiecesRoot]
+			if len(layer) != pieces*sha256.Size {
+				handle.Close()
+				return nil, fmt.Errorf("torrent has no piece layer for %v", file.Path)
+			}
+			for piece := 0; piece < pieces; piece++ {
+				n, readErr := io.ReadFull(handle, buffer)
+				if readErr != nil && readErr != io.ErrUnexpectedEOF {
+					failed += pieces - piece
+					break
+				}
+				expected := layer[piece*sha256.Size : (piece+1)*sha256.Size]
+				if merkleRoot(blockHashes(buffer[:n]), leavesPerPiece) != expected {
+					failed++
+				}
+			}
+		}
+		handle.Close()
+
+		report.Verified += pieces - failed
+		report.Failed += failed
+		if failed > 0 {
+			report.BadFiles = append(report.BadFiles, file.Path)
+		}
+	}
+	return report, nil
+}
+
+// blockHashes returns the SHA-256 hashes of the 16 KiB blocks of data.
+func blockHashes(data []byte) []string {
+	var hashes []string
+	for start := 0; start < len(data); start += v2BlockSize {
+		end := start + v2BlockSize
+		if end > len(data) {
+			end = len(data)
+		}
+		sum := sha256.Sum256(data[start:end])
+		hashes = append(hashes, string(sum[:]))
+	}
+	return hashes
+}
+
+// merkleRoot computes the root of a tree with the given number of leaves,
+// where leaves past the given hashes are zeros.
+func merkleRoot(hashes []string, leaves int) string {
+	zero := string(make([]byte, sha256.Size))
+	layer := make([]string, leaves)
+	for i := range layer {
+		if i < len(hashes) {
+			layer[i] = hashes[i]
+		} else {
+			layer[i] = zero
+		}
+	}
+	for len(layer) > 1 {
+		next := make([]string, len(layer)/2)
+		for i := range next {
+			sum := sha256.Sum256([]byte(layer[2*i] + layer[2*i+1]))
+			next[i] = string(sum[:])
+		}
+		layer = next
+	}
+	return layer[0]
+}
+
+func nextPowerOfTwo(n int) int {
+	power := 1
+	for power < n {
+		power *= 2
+	}
+	return power
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// printPieceReport prints the outcome and returns whether the data is good.
+func printPieceReport(name string, report *pieceReport) bool {
+	logInfo(fmt.Sprintf("%v: %d piece(s) verified, %d failed, %d skipped", name, report.Verified, report.Failed, report.Skipped))
+	for _, file := range report.BadFiles {
+		logWarn("Bad file", "torrent", name, "file", file)
+	}
+	return report.Failed == 0
+}
+
+// torrentMetadata is the exported metainfo of a torrent in a run.
+type torrentMetadata struct {
+	meta     *torrentMeta
+	unwanted map[string]bool
+}
+
+// exportMetadata exports the metainfo of the torrents while the engine is
+// still running, so their data can be verified after the transfer.
+func exportMetadata(engine TorrentEngine, torrents []Torrent) map[string]torrentMetadata {
+	metadata := map[string]torrentMetadata{}
+	exporter, ok := engine.(MetadataExporter)
+	if !ok {
+		logWarn(fmt.Sprintf("%v can't export torrent files, skipping piece verification.", engine.Name()))
+		return metadata
+	}
+	for _, t := range torrents {
+		data, unwanted, err := exporter.ExportMetadata(t.Hash)
+		if err != nil {
+			logWarn("Skipping piece verification", "torrent", t.Name, "error", err)
+			continue
+		}
+		meta, err := parseTorrentMeta(data)
+		if err != nil {
+			logWarn("Skipping piece verification", "torrent", t.Name, "error", err)
+			continue
+		}
+		metadata[t.Hash] = torrentMetadata{meta: meta, unwanted: unwanted}
+	}
+	return metadata
+}
+
+// verifyJobPieces verifies the local data of the transferred torrents
+// against their piece hashes.
+func verifyJobPieces(jobs []TransferJob, metadata map[string]torrentMetadata) error {
+	var failed []string
+	for _, job := range jobs {
+		torrent, ok := metadata[job.Hash]
+		if !ok || job.Extracted {
+			// Extracted files aren't the torrent's pieces.
+			continue
+		}
+		logInfo(fmt.Sprintf("Verifying pieces of %v...", job.Name))
+		meta := torrent.meta
+		report, err := verifyPieces(meta, func(file torrentFile) (string, bool) {
+			return job.Rules.Place(job, path.Join(meta.Name, file.Path), file.Length)
+		}, torrent.unwanted)
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%v (%v)", job.Name, err))
+			continue
+		}
+		if !printPieceReport(job.Name, report) {
+			failed = append(failed, job.Name)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("piece verification failed for %v", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// RunVerifyCommand verifies the data of a torrent in a local directory
+// against the torrent's piece hashes. It returns the process exit code.
+func RunVerifyCommand(args []string) int {
+	if len(args) != 2 {
+		fmt.Fprintln(os.Stderr, "Usage: do_torrent_downloader verify <dir> <torrent-file>")
+		return 2
+	}
+	dir, torrentFile := args[0], args[1]
+
+	data, err := ioutil.ReadFile(torrentFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	meta, err := parseTorrentMeta(data)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading %v: %v\n", torrentFile, err)
+		return 1
+	}
+
+	// Accept the torrent's own directory as well as the one containing it.
+	if _, err := os.Stat(filepath.Join(dir, meta.Name)); err != nil && filepath.Base(filepath.Clean(dir)) == meta.Name {
+		dir = filepath.Dir(filepath.Clean(dir))
+	}
+
+	report, err := verifyPieces(meta, meta.inDir(dir), nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error verifying %v: %v\n", meta.Name, err)
+		return 1
+	}
+	if !printPieceReport(meta.Name, report) {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,310 @@
+package doTorrentDownloader
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// bencode encodes strings, ints, lists and dictionaries for test torrents.
+func bencode(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return fmt.Sprintf("%d:%s", len(v), v)
+	case int:
+		return fmt.Sprintf("i%de", v)
+	case []interface{}:
+		encoded := "l"
+		for _, item := range v {
+			encoded += bencode(item)
+		}
+		return encoded + "e"
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		encoded := "d"
+		for _, key := range keys {
+			encoded += bencode(key) + bencode(v[key])
+		}
+		return encoded + "e"
+	}
+	panic(fmt.Sprintf("can't bencode %T", value))
+}
+
+// testData returns n bytes that differ by seed.
+func testData(n int, seed byte) []byte {
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte(i*7) + seed
+	}
+	return data
+}
+
+// writeTorrentFiles writes the contents below dir/name, by torrent path.
+func writeTorrentFiles(t *testing.T, dir string, name string, contents map[string][]byte) {
+	t.Helper()
+	for filePath, data := range contents {
+		local := filepath.Join(dir, name, filepath.FromSlash(filePath))
+		if err := os.MkdirAll(filepath.Dir(local), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(local, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// v1Pieces hashes the files as one stream, padding files as zeros.
+func v1Pieces(files []torrentFile, contents map[string][]byte, pieceLength int) string {
+	var stream []byte
+	for _, file := range files {
+		if file.Padding {
+			stream = append(stream, make([]byte, file.Length)...)
+		} else {
+			stream = append(stream, contents[file.Path]...)
+		}
+	}
+	var pieces string
+	for start := 0; start < len(stream); start += pieceLength {
+		end := start + pieceLength
+		if end > len(stream) {
+			end = len(stream)
+		}
+		sum := sha1.Sum(stream[start:end])
+		pieces += string(sum[:])
+	}
+	return pieces
+}
+
+func TestParseTorrentMeta(t *testing.T) {
+	multi := bencode(map[string]interface{}{
+		"info": map[string]interface{}{
+			"name":         "set",
+			"piece length": 16384,
+			"pieces":       string(make([]byte, 20)),
+			"files": []interface{}{
+				map[string]interface{}{"length": 10, "path": []interface{}{"dir", "a.bin"}},
+				map[string]interface{}{"length": 6, "path": []interface{}{".pad", "6"}, "attr": "p"},
+				map[string]interface{}{"length": 3, "path": []interface{}{"b.bin"}},
+			},
+		},
+	})
+	meta, err := parseTorrentMeta([]byte(multi))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantFiles := []torrentFile{
+		{Path: "dir/a.bin", Length: 10},
+		{Path: ".pad/6", Length: 6, Padding: true},
+		{Path: "b.bin", Length: 3},
+	}
+	if meta.Name != "set" || meta.PieceLength != 16384 || meta.IsV2 || !reflect.DeepEqual(meta.Files, wantFiles) {
+		t.Errorf("multi file torrent parsed as %+v", meta)
+	}
+
+	single := bencode(map[string]interface{}{
+		"info": map[string]interface{}{"name": "file.iso", "piece length": 16384, "length": 5, "pieces": string(make([]byte, 20))},
+	})
+	meta, err = parseTorrentMeta([]byte(single))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(meta.Files, []torrentFile{{Length: 5}}) {
+		t.Errorf("single file torrent has files %+v", meta.Files)
+	}
+
+	root := string(bytes.Repeat([]byte{1}, 32))
+	v2 := bencode(map[string]interface{}{
+		"info": map[string]interface{}{
+			"name":         "file.iso",
+			"piece length": 16384,
+			"meta version": 2,
+			"file tree": map[string]interface{}{
+				"file.iso": map[string]interface{}{"": map[string]interface{}{"length": 5, "pieces root": root}},
+			},
+		},
+	})
+	meta, err = parseTorrentMeta([]byte(v2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !meta.IsV2 || !reflect.DeepEqual(meta.Files, []torrentFile{{Length: 5, PiecesRoot: root}}) {
+		t.Errorf("v2 single file torrent parsed as %+v", meta)
+	}
+
+	for _, data := range []string{"i1e", bencode(map[string]interface{}{}), bencode(map[string]interface{}{"info": map[string]interface{}{"name": "x"}})} {
+		if _, err := parseTorrentMeta([]byte(data)); err == nil {
+			t.Errorf("parseTorrentMeta(%q) didn't fail", data)
+		}
+	}
+}
+
+func TestVerifyPiecesV1(t *testing.T) {
+	const pieceLength = 16
+	contents := map[string][]byte{
+		"a.bin":     testData(20, 1), // spans the first two pieces
+		"sub/b.bin": testData(9, 2),  // starts in the second piece
+		"c.bin":     testData(16, 3), // starts after the padding
+	}
+	files := []torrentFile{
+		{Path: "a.bin", Length: 20},
+		{Path: "sub/b.bin", Length: 9},
+		{Path: ".pad/3", Length: 3, Padding: true},
+		{Path: "c.bin", Length: 16},
+	}
+	meta := &torrentMeta{Name: "set", PieceLength: pieceLength, Files: files, Pieces: v1Pieces(files, contents, pieceLength)}
+
+	tests := []struct {
+		name     string
+		corrupt  string
+		unwanted map[string]bool
+		want     pieceReport
+	}{
+		{name: "intact", want: pieceReport{Verified: 3}},
+		{name: "corrupt spanning file", corrupt: "a.bin", want: pieceReport{Verified: 2, Failed: 1, BadFiles: []string{"a.bin", "sub/b.bin"}}},
+		{name: "corrupt file after padding", corrupt: "c.bin", want: pieceReport{Verified: 2, Failed: 1, BadFiles: []string{"c.bin"}}},
+		{name: "unwanted file", unwanted: map[string]bool{"sub/b.bin": true}, want: pieceReport{Verified: 2, Skipped: 1}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeTorrentFiles(t, dir, meta.Name, contents)
+			if test.corrupt != "" {
+				// Change the last byte, which is in the file's last piece.
+				data := append([]byte(nil), contents[test.corrupt]...)
+				data[len(data)-1] ^= 0xff
+				writeTorrentFiles(t, dir, meta.Name, map[string][]byte{test.corrupt: data})
+			}
+			report, err := verifyPieces(meta, meta.inDir(dir), test.unwanted)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(*report, test.want) {
+				t.Errorf("got %+v, want %+v", *report, test.want)
+			}
+		})
+	}
+}
+
+func TestVerifyPiecesV1ShortFile(t *testing.T) {
+	contents := map[string][]byte{"": testData(40, 4)}
+	files := []torrentFile{{Length: 40}}
+	meta := &torrentMeta{Name: "file.bin", PieceLength: 16, Files: files, Pieces: v1Pieces(files, contents, 16)}
+
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "file.bin"), contents[""][:30], 0644); err != nil {
+		t.Fatal(err)
+	}
+	report, err := verifyPieces(meta, meta.inDir(dir), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := pieceReport{Verified: 1, Failed: 2, BadFiles: []string{""}}
+	if !reflect.DeepEqual(*report, want) {
+		t.Errorf("got %+v, want %+v", *report, want)
+	}
+}
+
+func sha256String(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return string(sum[:])
+}
+
+func TestMerkleRoot(t *testing.T) {
+	zero := string(make([]byte, sha256.Size))
+	h := []string{sha256String("a"), sha256String("b"), sha256String("c")}
+
+	tests := []struct {
+		name   string
+		hashes []string
+		leaves int
+		want   string
+	}{
+		{"single leaf", h[:1], 1, h[0]},
+		{"two leaves", h[:2], 2, sha256String(h[0] + h[1])},
+		{"padded to four leaves", h, 4, sha256String(sha256String(h[0]+h[1]) + sha256String(h[2]+zero))},
+		{"padded to a piece", h[:1], 4, sha256String(sha256String(h[0]+zero) + sha256String(zero+zero))},
+	}
+	for _, test := range tests {
+		if got := merkleRoot(test.hashes, test.leaves); got != test.want {
+			t.Errorf("%v: got %x, want %x", test.name, got, test.want)
+		}
+	}
+
+	for n, want := range map[int]int{1: 1, 2: 2, 3: 4, 5: 8, 8: 8} {
+		if got := nextPowerOfTwo(n); got != want {
+			t.Errorf("nextPowerOfTwo(%d) = %d, want %d", n, got, want)
+		}
+	}
+}
+
+func TestVerifyPiecesV2(t *testing.T) {
+	const pieceLength = 2 * v2BlockSize
+	small := testData(v2BlockSize+100, 5)  // one piece of two blocks
+	large := testData(2*pieceLength+10, 6) // three pieces, the last one short
+
+	smallRoot := merkleRoot(blockHashes(small), 2)
+	var layer string
+	for start := 0; start < len(large); start += pieceLength {
+		end := start + pieceLength
+		if end > len(large) {
+			end = len(large)
+		}
+		layer += merkleRoot(blockHashes(large[start:end]), pieceLength/v2BlockSize)
+	}
+	largeRoot := sha256String("large") // only used to find the layer
+
+	meta := &torrentMeta{
+		Name:        "set",
+		PieceLength: pieceLength,
+		IsV2:        true,
+		Files: []torrentFile{
+			{Path: "large.bin", Length: int64(len(large)), PiecesRoot: largeRoot},
+			{Path: "small.bin", Length: int64(len(small)), PiecesRoot: smallRoot},
+		},
+		PieceLayers: map[string]string{largeRoot: layer},
+	}
+	contents := map[string][]byte{"large.bin": large, "small.bin": small}
+
+	tests := []struct {
+		name     string
+		corrupt  string
+		offset   int
+		unwanted map[string]bool
+		want     pieceReport
+	}{
+		{name: "intact", want: pieceReport{Verified: 4}},
+		{name: "corrupt middle piece", corrupt: "large.bin", offset: pieceLength + 1, want: pieceReport{Verified: 3, Failed: 1, BadFiles: []string{"large.bin"}}},
+		{name: "corrupt short last piece", corrupt: "large.bin", offset: len(large) - 1, want: pieceReport{Verified: 3, Failed: 1, BadFiles: []string{"large.bin"}}},
+		{name: "corrupt small file", corrupt: "small.bin", offset: v2BlockSize + 5, want: pieceReport{Verified: 3, Failed: 1, BadFiles: []string{"small.bin"}}},
+		{name: "unwanted file", unwanted: map[string]bool{"large.bin": true}, want: pieceReport{Verified: 1, Skipped: 3}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeTorrentFiles(t, dir, meta.Name, contents)
+			if test.corrupt != "" {
+				data := append([]byte(nil), contents[test.corrupt]...)
+				data[test.offset] ^= 0xff
+				writeTorrentFiles(t, dir, meta.Name, map[string][]byte{test.corrupt: data})
+			}
+			report, err := verifyPieces(meta, meta.inDir(dir), test.unwanted)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(*report, test.want) {
+				t.Errorf("got %+v, want %+v", *report, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,270 @@
+package doTorrentDownloader
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// plainInterval is how often the status is printed when stdout isn't a
+// terminal.
+const plainInterval = 30 * time.Second
+
+func getTerminalWidth() int {
+	width, _, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil {
+		return 80 // default
+	}
+	return width
+}
+
+// progressRow is a line of the in-place status view: "[label] name detail".
+type progressRow struct {
+	Label  string
+	Name   string
+	Detail string
+}
+
+// statusRenderer redraws a block of status lines in place, so that the
+// terminal doesn't scroll on every update. When stdout isn't a terminal it
+// prints plain lines every plainInterval instead.
+type statusRenderer struct {
+	lastLinesPrinted int
+	lastPlain        time.Time
+}
+
+// Reset forgets the previous block, e.g. after other output was printed
+// below it.
+func (r *statusRenderer) Reset() {
+	r.lastLinesPrinted = 0
+	r.lastPlain = time.Time{}
+}
+
+func (r *statusRenderer) Render(title string, rows []progressRow) {
+	if !isTerminal(os.Stdout) {
+		if time.Since(r.lastPlain) < plainInterval {
+			return
+		}
+		r.lastPlain = time.Now()
+		fmt.Printf("%s:\n", title)
+		for _, row := range rows {
+			fmt.Printf("  [%s] %s - %s\n", row.Label, row.Name, row.Detail)
+		}
+		return
+	}
+	if r.lastLinesPrinted > 0 {
+		fmt.Printf("\033[%dA", r.lastLinesPrinted)
+	}
+	fmt.Printf("\033[2K\r--- %s ---\n", title)
+	termWidth := getTerminalWidth()
+	for _, row := range rows {
+		// Construct parts to calculate length
+		prefix := fmt.Sprintf("[%s] ", row.Label)
+		suffix := " - " + row.Detail
+
+		availableSpace := termWidth - len(prefix) - len(suffix)
+		name := row.Name
+		if availableSpace < 5 { // Minimal space fallback
+			// If strictly enforcing no wrap, we might hide name.
+			if len(name) > 10 {
+				name = name[:7] + "..."
+			}
+		} else if len(name) > availableSpace {
+			name = name[:availableSpace-3] + "..."
+		}
+
+		fmt.Printf("\033[2K\r%s%s%s\n", prefix, name, suffix)
+	}
+	fmt.Printf("\033[2K\r%s\n", strings.Repeat("-", len(title)+8))
+	r.lastLinesPrinted = len(rows) + 2
+}
+
+func formatEta(seconds int64) string {
+	if seconds == EtaUnknown {
+		return "∞"
+	}
+	etaDuration := time.Duration(seconds) * time.Second
+	return fmt.Sprintf("%dm:%ds", int(etaDuration.Minutes()), int(etaDuration.Seconds())%60)
+}
+
+func formatSpeed(bytesPerSecond float64) string {
+	return fmt.Sprintf("%.2f MB/s", bytesPerSecond/1024/1024)
+}
+
+// torrentRows are the status lines of the torrents.
+func torrentRows(torrents []Torrent) []progressRow {
+	rows := make([]progressRow, 0, len(torrents))
+	for _, t := range torrents {
+		detail := fmt.Sprintf("%.2f%% - Speed: %s - ETA: %s", t.Progress*100, formatSpeed(float64(t.Dlspeed)), formatEta(t.Eta))
+		if t.State == StateSeeding {
+			detail += fmt.Sprintf(" - Ratio: %.2f", t.Ratio())
+		}
+		rows = append(rows, progressRow{
+			Label:  t.State,
+			Name:   t.Name,
+			Detail: detail,
+		})
+	}
+	return rows
+}
+
+// jobProgress is the transfer state of one job as shown in the view.
+type jobProgress struct {
+	TransferProgress
+	started    time.Time
+	lastBytes  int64
+	lastUpdate time.Time
+	speed      float64
+	lastEvent  time.Time
+}
+
+// transferTracker renders the progress reported by a transferer in the
+// same in-place view as the torrent status, one line per torrent and a
+// total, and prints a summary at the end.
+type transferTracker struct {
+	mu       sync.Mutex
+	renderer statusRenderer
+	finished bool
+	jobs     map[string]*jobProgress
+	order    []string
+	// torrents is the number of torrents of the jobs, whose archives may
+	// have been extracted into a job of their own.
+	torrents   int
+	started    time.Time
+	lastRender time.Time
+}
+
+func newTransferTracker(jobs []TransferJob) *transferTracker {
+	tracker := &transferTracker{jobs: map[string]*jobProgress{}, started: time.Now()}
+	hashes := map[string]bool{}
+	for _, job := range jobs {
+		tracker.jobs[job.Name] = &jobProgress{TransferProgress: TransferProgress{Job: job.Name}}
+		tracker.order = append(tracker.order, job.Name)
+		if job.Hash == "" || !hashes[job.Hash] {
+			tracker.torrents++
+		}
+		hashes[job.Hash] = true
+	}
+	return tracker
+}
+
+// Update takes a progress report. It is used as the transferer's progress
+// callback.
+func (tracker *transferTracker) Update(p TransferProgress) {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	job, ok := tracker.jobs[p.Job]
+	if !ok {
+		job = &jobProgress{}
+		tracker.jobs[p.Job] = job
+		tracker.order = append(tracker.order, p.Job)
+	}
+
+	now := time.Now()
+	if job.started.IsZero() {
+		job.started, job.lastUpdate, job.lastBytes = now, now, p.BytesTransferred
+	}
+	// Smooth the speed over the updates of the last seconds.
+	if elapsed := now.Sub(job.lastUpdate).Seconds(); elapsed >= 1 {
+		current := float64(p.BytesTransferred-job.lastBytes) / elapsed
+		job.speed = 0.7*job.speed + 0.3*current
+		job.lastBytes, job.lastUpdate = p.BytesTransferred, now
+	}
+	job.TransferProgress = p
+	if done := p.FilesTotal > 0 && p.FilesDone >= p.FilesTotal; done || now.Sub(job.lastEvent) >= time.Second {
+		emit("transfer_progress", transferProgressEvent{
+			Job: p.Job, File: p.File, BytesDone: p.BytesDone, BytesTotal: p.BytesTotal,
+			FilesDone: p.FilesDone, FilesTotal: p.FilesTotal, Speed: job.speed,
+		})
+		job.lastEvent = now
+	}
+
+	if now.Sub(tracker.lastRender) >= time.Second || tracker.isDone() {
+		tracker.render()
+		tracker.lastRender = now
+	}
+}
+
+// Notice prints a message above the view, e.g. a change of the bandwidth
+// limit, and redraws the view below it.
+func (tracker *transferTracker) Notice(message string) {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	logInfo(message)
+	if !tracker.finished {
+		tracker.renderer.Reset()
+		tracker.render()
+	}
+}
+
+func (tracker *transferTracker) isDone() bool {
+	for _, job := range tracker.jobs {
+		if job.FilesTotal == 0 || job.FilesDone < job.FilesTotal {
+			return false
+		}
+	}
+	return true
+}
+
+func (tracker *transferTracker) render() {
+	var rows []progressRow
+	var done, total int64
+	var speed float64
+	for _, name := range tracker.order {
+		job := tracker.jobs[name]
+		label := "waiting"
+		jobSpeed := 0.0
+		switch {
+		case job.FilesTotal > 0 && job.FilesDone >= job.FilesTotal:
+			label = "done"
+		case !job.started.IsZero():
+			label = "copying"
+			jobSpeed = job.speed
+		}
+		rows = append(rows, progressRow{Label: label, Name: name, Detail: transferDetail(job.BytesDone, job.BytesTotal, jobSpeed)})
+		done += job.BytesDone
+		total += job.BytesTotal
+		speed += jobSpeed
+	}
+	rows = append(rows, progressRow{Label: "total", Name: fmt.Sprintf("%d torrent(s)", len(tracker.order)), Detail: transferDetail(done, total, speed)})
+	tracker.renderer.Render("Transfer Status", rows)
+}
+
+func transferDetail(done int64, total int64, speed float64) string {
+	percent := 0.0
+	if total > 0 {
+		percent = float64(done) / float64(total) * 100
+	}
+	eta := int64(EtaUnknown)
+	if speed > 0 {
+		eta = int64(float64(total-done) / speed)
+	}
+	return fmt.Sprintf("%.2f%% - %s/%s - Speed: %s - ETA: %s", percent, formatBytes(done), formatBytes(total), formatSpeed(speed), formatEta(eta))
+}
+
+// Finish draws the final state and prints a summary of what was moved.
+func (tracker *transferTracker) Finish() {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	tracker.render()
+	tracker.finished = true
+
+	var files int
+	var transferred, total int64
+	for _, job := range tracker.jobs {
+		files += job.FilesDone
+		transferred += job.BytesTransferred
+		total += job.BytesTotal
+	}
+	elapsed := time.Since(tracker.started)
+	logInfo(fmt.Sprintf("Transfer finished: %d file(s) of %d torrent(s), %v moved of %v in %v (%s average).",
+		files, tracker.torrents, formatBytes(transferred), formatBytes(total),
+		elapsed.Round(time.Second), formatSpeed(float64(transferred)/elapsed.Seconds())))
+}
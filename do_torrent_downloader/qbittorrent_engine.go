@@ -0,0 +1,334 @@
+package doTorrentDownloader
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const qbittorrentPort = 8080
+
+type qbittorrentEngine struct {
+	conf      *config
+	sshClient SshClientOp
+	sid       string
+}
+
+// qbitTorrent is a torrent as returned by qBittorrent's torrents/info API.
+type qbitTorrent struct {
+	Hash       string  `json:"hash"`
+	Name       string  `json:"name"`
+	Progress   float64 `json:"progress"`
+	Dlspeed    int64   `json:"dlspeed"`
+	Upspeed    int64   `json:"upspeed"`
+	Eta        int64   `json:"eta"`
+	State      string  `json:"state"`
+	Size       int64   `json:"size"`
+	Downloaded int64   `json:"downloaded"`
+	Uploaded   int64   `json:"uploaded"`
+	Category   string  `json:"category"`
+	NumSeeds   int     `json:"num_seeds"`
+	NumLeechs  int     `json:"num_leechs"`
+}
+
+// qbitStates maps qBittorrent's states to the normalized ones.
+var qbitStates = map[string]string{
+	"downloading":        StateDownloading,
+	"forcedDL":           StateDownloading,
+	"stalledDL":          StateStalled,
+	"metaDL":             StateMetadata,
+	"forcedMetaDL":       StateMetadata,
+	"queuedDL":           StateQueued,
+	"queuedUP":           StateQueued,
+	"allocating":         StateChecking,
+	"checkingDL":         StateChecking,
+	"checkingUP":         StateChecking,
+	"checkingResumeData": StateChecking,
+	"moving":             StateChecking,
+	"pausedDL":           StatePaused,
+	"stoppedDL":          StatePaused,
+	"uploading":          StateSeeding,
+	"stalledUP":          StateSeeding,
+	"forcedUP":           StateSeeding,
+	"pausedUP":           StateCompleted,
+	"stoppedUP":          StateCompleted,
+	"error":              StateError,
+	"missingFiles":       StateError,
+}
+
+func newQbittorrentEngine(conf *config, sshClient SshClientOp) *qbittorrentEngine {
+	return &qbittorrentEngine{conf: conf, sshClient: sshClient}
+}
+
+func (engine *qbittorrentEngine) Name() string {
+	return "qBittorrent"
+}
+
+func (engine *qbittorrentEngine) WebUIPort() int {
+	return qbittorrentPort
+}
+
+func (engine *qbittorrentEngine) image() string {
+	return engineImage(engine.conf, fmt.Sprintf("linuxserver/qbittorrent:%s", engine.conf.QbittorrentVersion))
+}
+
+func (engine *qbittorrentEngine) Setup(attach bool) error {
+	if attach {
+		logInfo("Checking for a running qBittorrent to attach to...")
+		if engine.isHealthy() {
+			err := engine.login()
+			if err == nil {
+				logInfo("Attached to the running qBittorrent.")
+				return engine.applyPreferences()
+			}
+			return attachError(engine.Name(), err)
+		}
+		logInfo("Setting up qBittorrent again.")
+	}
+
+	if err := engine.setup(); err != nil {
+		return err
+	}
+	if err := engine.login(); err != nil {
+		return err
+	}
+	return engine.applyPreferences()
+}
+
+func (engine *qbittorrentEngine) Attach() error {
+	if !engine.isHealthy() {
+		return notRunningError(engine.Name())
+	}
+	if err := engine.login(); err != nil {
+		return attachError(engine.Name(), err)
+	}
+	return nil
+}
+
+// isHealthy reports whether a qbittorrent container is already running on
+// the droplet with the configured image and directories, so that it can be
+// attached to without a new setup.
+func (engine *qbittorrentEngine) isHealthy() bool {
+	return containerHealthy(engine.sshClient, "qbittorrent", engine.image(), []string{
+		fmt.Sprintf("%s:/downloads/incoming", strings.TrimSuffix(engine.conf.Qbit.IncomingDir, "/")),
+		fmt.Sprintf("%s:/downloads/completed", strings.TrimSuffix(engine.conf.Qbit.CompletedDir, "/")),
+	}, qbittorrentPort)
+}
+
+func (engine *qbittorrentEngine) setup() error {
+	conf := engine.conf
+	logInfo(fmt.Sprintf("Creating directories: %s, %s", conf.Qbit.IncomingDir, conf.Qbit.CompletedDir))
+	engine.sshClient.executeCmd(fmt.Sprintf("mkdir -p %s %s /root/config/qBittorrent", conf.Qbit.IncomingDir, conf.Qbit.CompletedDir))
+
+	logInfo("Configuring qBittorrent...")
+	pwdHash, err := generateQbittorrentHash(conf.QbittorrentPassword)
+	if err != nil {
+		return fmt.Errorf("error generating password hash: %v", err)
+	}
+
+	configContent := fmt.Sprintf(`[LegalNotice]
+Accepted=true
+
+[BitTorrent]
+Session\TempPath=/downloads/incoming
+Session\DefaultSavePath=/downloads/completed
+Session\TempPathEnabled=true
+[Preferences]
+# Disable OS Cache to prevent Linux write-buffer corruption (Libtorrent 2.0 bug)
+Advanced\DiskIOReadMode=DisableOSCache
+Advanced\DiskIOWriteMode=DisableOSCache
+# This ensures that EVERY finished file is verified against the hash before finishing
+Advanced\RecheckOnCompletion=true
+WebUI\Username=admin
+WebUI\Password_PBKDF2="%s"`, pwdHash)
+
+	engine.sshClient.executeCmd(fmt.Sprintf("cat <<'EOF' > /root/config/qBittorrent/qBittorrent.conf\n%s\nEOF", configContent))
+
+	listenPort := qbitListenPort(conf)
+	startContainer(engine.sshClient, "qbittorrent", engine.image(), fmt.Sprintf(`\
+		-e PUID=0 \
+		-e PGID=0 \
+		-e TZ=Etc/UTC \
+		-e WEBUI_PORT=%d \
+		-p %d:%d \
+		-p %d:%d \
+		-p %d:%d/udp \
+		-v %s:/downloads/incoming \
+		-v %s:/downloads/completed \
+		-v /root/config:/config`,
+		qbittorrentPort, qbittorrentPort, qbittorrentPort,
+		listenPort, listenPort, listenPort, listenPort,
+		conf.Qbit.IncomingDir,
+		conf.Qbit.CompletedDir), "")
+	return nil
+}
+
+// login authenticates with the WebUI and keeps the session ID for the
+// following API calls.
+func (engine *qbittorrentEngine) login() error {
+	logInfo("Waiting for qBittorrent to initialize...")
+	waitForPort(engine.sshClient, qbittorrentPort)
+
+	logInfo("Authenticating...")
+	// Authenticate and capture cookies
+	// qBittorrent v4.x login: POST /api/v2/auth/login with username/password
+	// Default username is admin
+	loginCmd := fmt.Sprintf("curl -i -X POST -d username=admin --data-urlencode %s http://localhost:%d/api/v2/auth/login", shellQuote("password="+engine.conf.QbittorrentPassword), qbittorrentPort)
+	loginOut := engine.sshClient.executeCmd(loginCmd)
+
+	var sid string
+	// Example Header: Set-Cookie: SID=e6c4...; HttpOnly; path=/
+	lines := strings.Split(loginOut, "\n")
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(strings.ToLower(line), "set-cookie:") && strings.Contains(line, "SID=") {
+			parts := strings.Split(line, "SID=")
+			if len(parts) > 1 {
+				// The value might be terminated by ;
+				val := strings.Split(parts[1], ";")[0]
+				sid = val
+				break
+			}
+		}
+	}
+
+	if sid == "" {
+		return fmt.Errorf("could not extract SID from login response")
+	}
+
+	logInfo("Authenticated. Session ID obtained.")
+	engine.sid = sid
+	return nil
+}
+
+func (engine *qbittorrentEngine) Teardown() {
+	logInfo("Stopping and removing qbittorrent container to stop seeding...")
+	removeContainer(engine.sshClient, "qbittorrent")
+	logInfo("qBittorrent container removed.")
+}
+
+func (engine *qbittorrentEngine) List() ([]Torrent, error) {
+	cmd := fmt.Sprintf("curl -s --cookie 'SID=%s' http://localhost:%d/api/v2/torrents/info", engine.sid, qbittorrentPort)
+	output := engine.sshClient.executeCmd(cmd)
+
+	var qbitTorrents []qbitTorrent
+	err := json.Unmarshal([]byte(output), &qbitTorrents)
+	if err != nil {
+		return nil, err
+	}
+
+	torrents := make([]Torrent, 0, len(qbitTorrents))
+	for _, t := range qbitTorrents {
+		state, ok := qbitStates[t.State]
+		if !ok {
+			state = t.State
+		}
+		eta := t.Eta
+		if eta == 8640000 { // qBittorrent returns 8640000 for infinity/unknown
+			eta = EtaUnknown
+		}
+		torrents = append(torrents, Torrent{
+			Hash:       t.Hash,
+			Name:       t.Name,
+			Progress:   t.Progress,
+			Dlspeed:    t.Dlspeed,
+			Upspeed:    t.Upspeed,
+			Eta:        eta,
+			State:      state,
+			Size:       t.Size,
+			Downloaded: t.Downloaded,
+			Uploaded:   t.Uploaded,
+			Category:   t.Category,
+			Seeds:      t.NumSeeds,
+			Peers:      t.NumLeechs,
+		})
+	}
+	return torrents, nil
+}
+
+func (engine *qbittorrentEngine) Status(hash string) (Torrent, error) {
+	return findTorrent(engine, hash)
+}
+
+func (engine *qbittorrentEngine) Add(links []string) error {
+	logInfo("Adding torrents...")
+	for _, link := range links {
+		// Endpoint: /api/v2/torrents/add
+		// Form data: urls=...
+		if err := engine.post(fmt.Sprintf("-F %s", shellQuote("urls="+link)), "torrents/add"); err != nil {
+			return err
+		}
+	}
+	logInfo("Torrents added.")
+	return nil
+}
+
+// Pause pauses the torrents with the given hashes. qBittorrent 5 renamed
+// the pause endpoint to "stop", so fall back to the old name when the new
+// one is not found.
+func (engine *qbittorrentEngine) Pause(hashes []string) error {
+	return engine.post(fmt.Sprintf("-d 'hashes=%s'", strings.Join(hashes, "|")), "torrents/stop", "torrents/pause")
+}
+
+func (engine *qbittorrentEngine) Resume(hashes []string) error {
+	return engine.post(fmt.Sprintf("-d 'hashes=%s'", strings.Join(hashes, "|")), "torrents/start", "torrents/resume")
+}
+
+func (engine *qbittorrentEngine) Remove(hashes []string, deleteFiles bool) error {
+	return engine.post(fmt.Sprintf("-d 'hashes=%s&deleteFiles=%t'", strings.Join(hashes, "|"), deleteFiles), "torrents/delete")
+}
+
+// post sends the curl data arguments to the first endpoint that exists and
+// returns an error unless qBittorrent answers with 200.
+func (engine *qbittorrentEngine) post(dataArgs string, endpoints ...string) error {
+	var status string
+	for _, endpoint := range endpoints {
+		cmd := fmt.Sprintf("curl -s -o /dev/null -w '%%{http_code}' --cookie 'SID=%s' -X POST %s http://localhost:%d/api/v2/%s", engine.sid, dataArgs, qbittorrentPort, endpoint)
+		status = strings.TrimSpace(engine.sshClient.executeCmd(cmd))
+		if status == "200" {
+			return nil
+		}
+		if status != "404" {
+			break
+		}
+	}
+	return fmt.Errorf("qBittorrent API %s returned status %q", endpoints[0], status)
+}
+
+// ExportMetadata fetches the .torrent file through the torrents/export API
+// of qBittorrent 4.5 and later, and the files skipped with priority 0.
+func (engine *qbittorrentEngine) ExportMetadata(hash string) ([]byte, map[string]bool, error) {
+	cmd := fmt.Sprintf("curl -s -f --cookie 'SID=%s' 'http://localhost:%d/api/v2/torrents/export?hash=%s' | base64 -w0", engine.sid, qbittorrentPort, hash)
+	metainfo, err := base64.StdEncoding.DecodeString(strings.TrimSpace(engine.sshClient.executeCmd(cmd)))
+	if err != nil || len(metainfo) == 0 {
+		return nil, nil, fmt.Errorf("could not export torrent %v, qBittorrent 4.5 or later is needed", hash)
+	}
+
+	cmd = fmt.Sprintf("curl -s --cookie 'SID=%s' 'http://localhost:%d/api/v2/torrents/files?hash=%s'", engine.sid, qbittorrentPort, hash)
+	var files []struct {
+		Name     string `json:"name"`
+		Priority int    `json:"priority"`
+	}
+	if err := json.Unmarshal([]byte(engine.sshClient.executeCmd(cmd)), &files); err != nil {
+		return nil, nil, err
+	}
+	meta, err := parseTorrentMeta(metainfo)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	unwanted := map[string]bool{}
+	for _, file := range files {
+		if file.Priority == 0 {
+			// File names include the torrent's root folder, single file
+			// torrents are named after the file itself.
+			name := strings.TrimPrefix(file.Name, meta.Name+"/")
+			if name == meta.Name {
+				name = ""
+			}
+			unwanted[name] = true
+		}
+	}
+	return metainfo, unwanted, nil
+}
@@ -0,0 +1,164 @@
+package doTorrentDownloader
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	prefInt    = "integer"
+	prefFloat  = "number"
+	prefBool   = "boolean"
+	prefString = "string"
+)
+
+// qbitPreferenceTypes are the qBittorrent preferences that can be set with
+// qbit.preferences in the config, as named by the app/setPreferences API.
+var qbitPreferenceTypes = map[string]string{
+	// Connections
+	"listen_port":             prefInt,
+	"random_port":             prefBool,
+	"upnp":                    prefBool,
+	"max_connec":              prefInt,
+	"max_connec_per_torrent":  prefInt,
+	"max_uploads":             prefInt,
+	"max_uploads_per_torrent": prefInt,
+	// Speed
+	"dl_limit":                              prefInt,
+	"up_limit":                              prefInt,
+	"limit_utp_rate":                        prefBool,
+	"limit_tcp_overhead":                    prefBool,
+	"bittorrent_protocol":                   prefInt,
+	"enable_multi_connections_from_same_ip": prefBool,
+	// Privacy
+	"dht":            prefBool,
+	"pex":            prefBool,
+	"lsd":            prefBool,
+	"encryption":     prefInt,
+	"anonymous_mode": prefBool,
+	// Queueing
+	"queueing_enabled":         prefBool,
+	"max_active_downloads":     prefInt,
+	"max_active_torrents":      prefInt,
+	"max_active_uploads":       prefInt,
+	"dont_count_slow_torrents": prefBool,
+	// Seeding limits
+	"max_ratio_enabled":        prefBool,
+	"max_ratio":                prefFloat,
+	"max_seeding_time_enabled": prefBool,
+	"max_seeding_time":         prefInt,
+	"max_ratio_act":            prefInt,
+	// Trackers
+	"add_trackers_enabled": prefBool,
+	"add_trackers":         prefString,
+	// Disk
+	"preallocate_all":              prefBool,
+	"incomplete_files_ext":         prefBool,
+	"async_io_threads":             prefInt,
+	"hashing_threads":              prefInt,
+	"file_pool_size":               prefInt,
+	"checking_memory_use":          prefInt,
+	"disk_cache":                   prefInt,
+	"disk_queue_size":              prefInt,
+	"enable_piece_extent_affinity": prefBool,
+}
+
+var dropletSizePattern = regexp.MustCompile(`(\d+)vcpu-(\d+)gb`)
+
+// defaultQbitPreferences scales connection and queueing limits to the
+// droplet's size so that small droplets don't run out of memory and large
+// ones aren't held back by qBittorrent's conservative defaults.
+func defaultQbitPreferences(sizeSlug string) map[string]interface{} {
+	vcpus, memoryGB := 1, 1
+	if match := dropletSizePattern.FindStringSubmatch(sizeSlug); match != nil {
+		vcpus, _ = strconv.Atoi(match[1])
+		memoryGB, _ = strconv.Atoi(match[2])
+	}
+
+	maxConnections := int(math.Min(float64(200*memoryGB), 2000))
+	return map[string]interface{}{
+		"max_connec":             maxConnections,
+		"max_connec_per_torrent": maxConnections / 4,
+		"queueing_enabled":       true,
+		"max_active_downloads":   int(math.Max(2, float64(2*vcpus))),
+		"max_active_torrents":    int(math.Max(4, float64(4*vcpus))),
+		"async_io_threads":       int(math.Max(4, float64(4*vcpus))),
+		"hashing_threads":        vcpus,
+	}
+}
+
+// validateQbitPreferences checks that every preference is known and has a
+// value of the right type.
+func validateQbitPreferences(preferences map[string]interface{}) error {
+	var problems []string
+	for key, value := range preferences {
+		kind, ok := qbitPreferenceTypes[key]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("unknown preference %q", key))
+			continue
+		}
+		if !preferenceHasType(value, kind) {
+			problems = append(problems, fmt.Sprintf("preference %q must be a %s, got %v", key, kind, value))
+		}
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("invalid qbit.preferences: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func preferenceHasType(value interface{}, kind string) bool {
+	switch v := value.(type) {
+	case int:
+		return kind == prefInt || kind == prefFloat
+	case float64:
+		// JSON decodes every number as float64.
+		return kind == prefFloat || (kind == prefInt && v == math.Trunc(v))
+	case bool:
+		return kind == prefBool
+	case string:
+		return kind == prefString
+	}
+	return false
+}
+
+// qbitPreferences merges the configured preferences over the size-aware
+// defaults.
+func qbitPreferences(conf *config) map[string]interface{} {
+	preferences := defaultQbitPreferences(conf.Size)
+	for key, value := range conf.Qbit.Preferences {
+		preferences[key] = value
+	}
+	return preferences
+}
+
+// qbitListenPort is the port qBittorrent accepts peers on, which has to be
+// published by its container.
+func qbitListenPort(conf *config) int {
+	if port, ok := conf.Qbit.Preferences["listen_port"]; ok {
+		switch v := port.(type) {
+		case int:
+			return v
+		case float64:
+			return int(v)
+		}
+	}
+	return 6881
+}
+
+// applyPreferences sets the preferences through the app/setPreferences API.
+func (engine *qbittorrentEngine) applyPreferences() error {
+	preferences := qbitPreferences(engine.conf)
+	data, err := json.Marshal(preferences)
+	if err != nil {
+		return err
+	}
+	logInfo(fmt.Sprintf("Applying %d qBittorrent preferences...", len(preferences)))
+	return engine.post(fmt.Sprintf("--data-urlencode %s", shellQuote("json="+string(data))), "app/setPreferences")
+}
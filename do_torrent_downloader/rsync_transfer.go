@@ -0,0 +1,239 @@
+package doTorrentDownloader
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// rsyncTransferer shells out to the local rsync and OpenSSH binaries.
+type rsyncTransferer struct {
+	conf      *config
+	sshClient SshClientOp
+	ip        string
+	control   *transferControl
+}
+
+// rsyncProgressPattern matches the lines of --info=progress2, e.g.
+// "  1,234,567  45%   10.00MB/s    0:00:12 (xfr#3, to-chk=5/10)".
+var rsyncProgressPattern = regexp.MustCompile(`^\s*([\d,]+)\s+(\d+)%\s+\S+\s+\S+(?:\s+\(xfr#(\d+), (?:ir|to)-chk=(\d+)/(\d+)\))?`)
+
+func newRsyncTransferer(conf *config, sshClient SshClientOp, ip string, control *transferControl) *rsyncTransferer {
+	return &rsyncTransferer{conf: conf, sshClient: sshClient, ip: ip, control: control}
+}
+
+func (transferer *rsyncTransferer) Name() string {
+	return "rsync"
+}
+
+func (transferer *rsyncTransferer) Transfer(jobs []TransferJob, progress func(TransferProgress)) error {
+	var failed []string
+	for _, job := range jobs {
+		var err error
+		if job.Rules.Active() {
+			err = transferer.transferPlaced(job, progress)
+		} else {
+			err = transferer.sync(job, job.RemotePath, job.LocalDir, nil, progress)
+		}
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%v: %v", job.Name, err))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("rsync failed for %v", failed)
+	}
+	return nil
+}
+
+// transferPlaced transfers the files the job's rules let through with one
+// rsync per pair of remote and local directory.
+func (transferer *rsyncTransferer) transferPlaced(job TransferJob, progress func(TransferProgress)) error {
+	files, err := listRemoteFiles(transferer.sshClient, job)
+	if err != nil {
+		return err
+	}
+
+	type group struct {
+		remoteDir string
+		localDir  string
+		names     []string
+		size      int64
+	}
+	groups := map[string]*group{}
+	var order []string
+	state := TransferProgress{Job: job.Name}
+	for _, file := range files {
+		localPath, ok := job.Rules.Place(job, file.name, file.size)
+		if !ok {
+			continue
+		}
+		remoteDir := path.Join(path.Dir(job.RemotePath), path.Dir(file.name))
+		key := remoteDir + "\x00" + filepath.Dir(localPath)
+		if groups[key] == nil {
+			groups[key] = &group{remoteDir: remoteDir, localDir: filepath.Dir(localPath)}
+			order = append(order, key)
+		}
+		groups[key].names = append(groups[key].names, path.Base(file.name))
+		groups[key].size += file.size
+		state.BytesTotal += file.size
+		state.FilesTotal++
+	}
+
+	for _, key := range order {
+		g := groups[key]
+		if err := os.MkdirAll(g.localDir, 0755); err != nil {
+			return err
+		}
+		// Report the progress of the group on top of the finished ones.
+		done := state
+		err := transferer.sync(job, g.remoteDir+"/", g.localDir, g.names, func(p TransferProgress) {
+			if progress == nil {
+				return
+			}
+			report := done
+			report.BytesDone += min64(p.BytesDone, g.size)
+			report.BytesTransferred += p.BytesTransferred
+			report.FilesDone += p.FilesDone
+			progress(report)
+		})
+		if err != nil {
+			return err
+		}
+		state.BytesDone += g.size
+		state.FilesDone += len(g.names)
+		if progress != nil {
+			progress(state)
+		}
+	}
+	return nil
+}
+
+// sync runs rsync from the remote path to the local directory, only for the
+// given files of the remote directory if any. rsync can't change its
+// bandwidth limit while running, so it is stopped when the schedule changes
+// or the disk space drops below the reserve and started again once it may,
+// resuming the partial files.
+func (transferer *rsyncTransferer) sync(job TransferJob, remotePath string, localDir string, files []string, progress func(TransferProgress)) error {
+	for {
+		transferer.control.Wait(localDir)
+		restarted, err := transferer.run(job, remotePath, localDir, files, progress)
+		if !restarted {
+			return err
+		}
+	}
+}
+
+// run runs rsync once with the current bandwidth limit. It stops rsync and
+// returns restarted when the limit changes or the disk is short of space.
+func (transferer *rsyncTransferer) run(job TransferJob, remotePath string, localDir string, files []string, progress func(TransferProgress)) (bool, error) {
+	// Rsync the files: https://github.com/refola/golang/blob/master/backup/rsync.go
+	args := []string{
+		"-e",
+		fmt.Sprintf("ssh -o StrictHostKeyChecking=no -i %v", transferer.conf.SshPrivateKeyPath),
+		"-a",
+		"--partial",
+		"--protect-args",
+		"--info=progress2",
+		"--no-inc-recursive",
+	}
+	rate := rateUnlimited
+	if limiter := transferer.control.bandwidth(); limiter != nil {
+		rate = limiter.Rate()
+		if rate != rateUnlimited {
+			args = append(args, fmt.Sprintf("--bwlimit=%d", max64(rate/1024, 1)))
+		}
+	}
+	if files != nil {
+		args = append(args, "--from0", "--files-from=-")
+	}
+	args = append(args, fmt.Sprintf("%v@%v:%v", "root", transferer.ip, remotePath), localDir+"/")
+
+	cmd := exec.Command("rsync", args...)
+	if files != nil {
+		cmd.Stdin = strings.NewReader(strings.Join(files, "\x00") + "\x00")
+	}
+	cmd.Stderr = os.Stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return false, err
+	}
+	if err := cmd.Start(); err != nil {
+		return false, err
+	}
+
+	var restarted int32
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(10 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			if transferer.control.mustPause(localDir, rate) {
+				atomic.StoreInt32(&restarted, 1)
+				cmd.Process.Kill()
+				return
+			}
+		}
+	}()
+
+	parseRsyncProgress(job, bufio.NewScanner(stdout), progress)
+	err = cmd.Wait()
+	close(done)
+	if atomic.LoadInt32(&restarted) == 1 {
+		return true, nil
+	}
+	return false, err
+}
+
+// parseRsyncProgress turns rsync's progress output, which redraws a single
+// line with carriage returns, into progress reports for the job.
+func parseRsyncProgress(job TransferJob, scanner *bufio.Scanner, progress func(TransferProgress)) {
+	scanner.Split(scanLinesOrCarriageReturns)
+	for scanner.Scan() {
+		match := rsyncProgressPattern.FindStringSubmatch(scanner.Text())
+		if match == nil || progress == nil {
+			continue
+		}
+		transferred, _ := strconv.ParseInt(strings.ReplaceAll(match[1], ",", ""), 10, 64)
+		percent, _ := strconv.ParseInt(match[2], 10, 64)
+
+		report := TransferProgress{
+			Job:              job.Name,
+			BytesDone:        transferred,
+			BytesTotal:       transferred,
+			BytesTransferred: transferred,
+		}
+		if percent > 0 {
+			report.BytesTotal = transferred * 100 / percent
+		}
+		if match[5] != "" {
+			toCheck, _ := strconv.Atoi(match[4])
+			report.FilesTotal, _ = strconv.Atoi(match[5])
+			report.FilesDone = report.FilesTotal - toCheck
+		}
+		progress(report)
+	}
+}
+
+func scanLinesOrCarriageReturns(data []byte, atEOF bool) (int, []byte, error) {
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
@@ -0,0 +1,148 @@
+package doTorrentDownloader
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v2"
+)
+
+const redacted = "[redacted]"
+
+// secrets are the values redacted from everything printed. They are the
+// resolved secret fields of the config and the generated WebUI password.
+var secrets struct {
+	mu     sync.Mutex
+	values []string
+}
+
+// registerSecret adds the value to the redacted values, also as shellQuote
+// escapes it within the commands run on the droplet.
+func registerSecret(value string) {
+	if value == "" {
+		return
+	}
+	secrets.mu.Lock()
+	defer secrets.mu.Unlock()
+	quoted := shellQuote(value)
+	for _, form := range []string{value, quoted[1 : len(quoted)-1]} {
+		known := false
+		for _, existing := range secrets.values {
+			known = known || existing == form
+		}
+		if !known {
+			secrets.values = append(secrets.values, form)
+		}
+	}
+}
+
+// redact replaces the registered secrets in s.
+func redact(s string) string {
+	secrets.mu.Lock()
+	defer secrets.mu.Unlock()
+	for _, value := range secrets.values {
+		s = strings.ReplaceAll(s, value, redacted)
+	}
+	return s
+}
+
+// secretFields are the fields of the config that hold secrets and may refer
+// to where the secret is kept instead.
+func secretFields(conf *config) map[string]*string {
+	fields := map[string]*string{
+		"digital_ocean_pat":    &conf.DigitalOceanPat,
+		"qbittorrent_password": &conf.QbittorrentPassword,
+	}
+	for i := range conf.Notify.Channels {
+		fields[fmt.Sprintf("notify channel %d token", i+1)] = &conf.Notify.Channels[i].Token
+		fields[fmt.Sprintf("notify channel %d password", i+1)] = &conf.Notify.Channels[i].Password
+	}
+	return fields
+}
+
+// resolveSecrets replaces the secret fields that refer to an environment
+// variable, a file or a command with the secret, and registers the secrets
+// for redaction.
+func resolveSecrets(conf *config) error {
+	for name, field := range secretFields(conf) {
+		value, err := resolveSecret(*field)
+		if err != nil {
+			return fmt.Errorf("error resolving %v: %v", name, err)
+		}
+		*field = value
+		registerSecret(value)
+	}
+	return nil
+}
+
+// resolveSecret returns the secret of a value of the form env:NAME,
+// file:PATH or cmd:COMMAND, e.g. "cmd:pass show do/token". Other values are
+// the secret itself. Trailing newlines of files and command output are
+// dropped. Errors never contain the secret.
+func resolveSecret(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, "env:"):
+		name := strings.TrimPrefix(value, "env:")
+		secret, ok := os.LookupEnv(name)
+		if !ok || secret == "" {
+			return "", fmt.Errorf("environment variable %v is not set", name)
+		}
+		return secret, nil
+	case strings.HasPrefix(value, "file:"):
+		path := strings.TrimPrefix(value, "file:")
+		if strings.HasPrefix(path, "~/") {
+			home, _ := os.UserHomeDir()
+			path = filepath.Join(home, path[2:])
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	case strings.HasPrefix(value, "cmd:"):
+		command := strings.TrimPrefix(value, "cmd:")
+		var cmd *exec.Cmd
+		if runtime.GOOS == "windows" {
+			cmd = exec.Command("cmd", "/C", command)
+		} else {
+			cmd = exec.Command("sh", "-c", command)
+		}
+		var stdout bytes.Buffer
+		cmd.Stdout = &stdout
+		cmd.Stderr = os.Stderr
+		cmd.Stdin = os.Stdin
+		if err := cmd.Run(); err != nil {
+			return "", fmt.Errorf("command %q failed: %v", command, err)
+		}
+		secret := strings.TrimRight(stdout.String(), "\r\n")
+		if secret == "" {
+			return "", fmt.Errorf("command %q printed nothing", command)
+		}
+		return secret, nil
+	}
+	return value, nil
+}
+
+// String returns the config as YAML with the secrets redacted.
+func (conf *config) String() string {
+	printed := *conf
+	// The channels would be shared with conf.
+	printed.Notify.Channels = append([]notifyChannelConfig(nil), conf.Notify.Channels...)
+	for _, field := range secretFields(&printed) {
+		if *field != "" {
+			*field = redacted
+		}
+	}
+	data, err := yaml.Marshal(&printed)
+	if err != nil {
+		return redacted
+	}
+	return redact(string(data))
+}
@@ -0,0 +1,133 @@
+package doTorrentDownloader
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResolveSecret(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	if err := ioutil.WriteFile(filepath.Join(home, "token"), []byte("from-home\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "token")
+	if err := ioutil.WriteFile(file, []byte("from-file\r\n\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("DOTD_TEST_SECRET", "from-env")
+
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"plain-secret", "plain-secret"},
+		{"", ""},
+		{"env:DOTD_TEST_SECRET", "from-env"},
+		{"file:" + file, "from-file"},
+		{"file:~/token", "from-home"},
+		{`cmd:printf 'from-cmd\n\n'`, "from-cmd"},
+		{"cmd:echo first line; echo second line", "first line\nsecond line"},
+	}
+	for _, test := range tests {
+		got, err := resolveSecret(test.value)
+		if err != nil {
+			t.Errorf("resolveSecret(%q) failed: %v", test.value, err)
+		} else if got != test.want {
+			t.Errorf("resolveSecret(%q) = %q, want %q", test.value, got, test.want)
+		}
+	}
+}
+
+func TestResolveSecretErrors(t *testing.T) {
+	t.Setenv("DOTD_TEST_EMPTY", "")
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"env:DOTD_TEST_MISSING", "environment variable DOTD_TEST_MISSING is not set"},
+		{"env:DOTD_TEST_EMPTY", "environment variable DOTD_TEST_EMPTY is not set"},
+		{"file:" + filepath.Join(t.TempDir(), "missing"), "no such file"},
+		{"cmd:printf ''", `command "printf ''" printed nothing`},
+		{"cmd:echo hunter2; exit 3", `command "echo hunter2; exit 3" failed: exit status 3`},
+	}
+	for _, test := range tests {
+		_, err := resolveSecret(test.value)
+		if err == nil {
+			t.Errorf("resolveSecret(%q) didn't fail", test.value)
+		} else if !strings.Contains(err.Error(), test.want) {
+			t.Errorf("resolveSecret(%q) failed with %q, want %q", test.value, err, test.want)
+		}
+	}
+}
+
+func TestResolveSecrets(t *testing.T) {
+	t.Setenv("DOTD_TEST_PAT", "pat-from-env")
+	conf := defaultConfig()
+	conf.DigitalOceanPat = "env:DOTD_TEST_PAT"
+	conf.Notify.Channels = []notifyChannelConfig{{Type: "ntfy", Token: "cmd:echo ntfy-token"}}
+	if err := resolveSecrets(conf); err != nil {
+		t.Fatal(err)
+	}
+	if conf.DigitalOceanPat != "pat-from-env" || conf.Notify.Channels[0].Token != "ntfy-token" {
+		t.Errorf("resolved to %q and %q", conf.DigitalOceanPat, conf.Notify.Channels[0].Token)
+	}
+	if got := redact("token ntfy-token and pat pat-from-env"); got != "token [redacted] and pat [redacted]" {
+		t.Errorf("resolved secrets weren't registered: %q", got)
+	}
+
+	conf.Notify.Channels[0].Password = "env:DOTD_TEST_MISSING"
+	if err := resolveSecrets(conf); err == nil || !strings.Contains(err.Error(), "notify channel 1 password") {
+		t.Errorf("resolveSecrets failed with %v, want the field named", err)
+	}
+}
+
+func TestRedact(t *testing.T) {
+	registerSecret("s3cr'et")
+	registerSecret("s3cr'et")
+	registerSecret("")
+
+	tests := []struct {
+		s    string
+		want string
+	}{
+		{"password s3cr'et", "password [redacted]"},
+		{"curl -u " + shellQuote("admin:s3cr'et"), "curl -u 'admin:[redacted]'"},
+		{"-e RPC_SECRET=" + shellQuote("s3cr'et"), "-e RPC_SECRET='[redacted]'"},
+		{"nothing secret", "nothing secret"},
+	}
+	for _, test := range tests {
+		if got := redact(test.s); got != test.want {
+			t.Errorf("redact(%q) = %q, want %q", test.s, got, test.want)
+		}
+	}
+}
+
+func TestConfigString(t *testing.T) {
+	conf := defaultConfig()
+	conf.DigitalOceanPat = "dop_v1_abc"
+	conf.QbittorrentPassword = "webui-pass"
+	conf.Notify.Channels = []notifyChannelConfig{{Type: "email", Password: "smtp-pass", Token: "hook-token"}}
+	// A secret is also redacted where it is used in other fields.
+	registerSecret("hook-token")
+	conf.Notify.Channels[0].URL = "https://example.com/?token=hook-token"
+
+	printed := conf.String()
+	for _, secret := range []string{"dop_v1_abc", "webui-pass", "smtp-pass", "hook-token"} {
+		if strings.Contains(printed, secret) {
+			t.Errorf("the printed config contains %q:\n%v", secret, printed)
+		}
+	}
+	if !strings.Contains(printed, "digital_ocean_pat: '[redacted]'") {
+		t.Errorf("the printed config doesn't show the redacted token:\n%v", printed)
+	}
+	if conf.DigitalOceanPat != "dop_v1_abc" || conf.Notify.Channels[0].Password != "smtp-pass" {
+		t.Error("String changed the config")
+	}
+
+	if printed := defaultConfig().String(); strings.Contains(printed, redacted) {
+		t.Errorf("unset secrets are shown as redacted:\n%v", printed)
+	}
+}
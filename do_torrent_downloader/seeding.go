@@ -0,0 +1,82 @@
+package doTorrentDownloader
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// defaultSeedingTime caps seeding by ratio alone, as torrents without peers
+// never reach it.
+const defaultSeedingTime = 24 * time.Hour
+
+// seedingPolicy keeps completed torrents seeding until all of them reached
+// the ratio or the time has passed since the downloads completed,
+// whichever comes first.
+type seedingPolicy struct {
+	ratio    float64
+	duration time.Duration
+}
+
+// newSeedingPolicy returns nil when torrents aren't seeded.
+func newSeedingPolicy(conf *config) (*seedingPolicy, error) {
+	policy := &seedingPolicy{ratio: conf.Seeding.Ratio}
+	if policy.ratio < 0 {
+		return nil, fmt.Errorf("invalid seeding ratio %v", policy.ratio)
+	}
+	if conf.Seeding.Time != "" {
+		duration, err := time.ParseDuration(conf.Seeding.Time)
+		if err != nil || duration < 0 {
+			return nil, fmt.Errorf("invalid seeding time %q, use e.g. 2h", conf.Seeding.Time)
+		}
+		policy.duration = duration
+	}
+	if policy.ratio == 0 && policy.duration == 0 {
+		return nil, nil
+	}
+	if policy.duration == 0 {
+		policy.duration = defaultSeedingTime
+	}
+	return policy, nil
+}
+
+// String describes the policy, e.g. "until ratio 1.0 or 2h".
+func (policy *seedingPolicy) String() string {
+	if policy.ratio == 0 {
+		return fmt.Sprintf("for %v", formatDuration(policy.duration))
+	}
+	return fmt.Sprintf("until ratio %.1f or %v", policy.ratio, formatDuration(policy.duration))
+}
+
+// formatDuration formats durations without their zero units, e.g. 2h
+// rather than 2h0m0s.
+func formatDuration(duration time.Duration) string {
+	formatted := duration.String()
+	if strings.HasSuffix(formatted, "m0s") {
+		formatted = strings.TrimSuffix(formatted, "0s")
+	}
+	if strings.HasSuffix(formatted, "h0m") {
+		formatted = strings.TrimSuffix(formatted, "0m")
+	}
+	return formatted
+}
+
+// Done tells whether seeding since the given time is enough, and why.
+func (policy *seedingPolicy) Done(torrents []Torrent, since time.Time) (bool, string) {
+	if time.Since(since) >= policy.duration {
+		return true, fmt.Sprintf("seeded for %v", formatDuration(policy.duration))
+	}
+	if policy.ratio == 0 {
+		return false, ""
+	}
+	var below []string
+	for _, t := range torrents {
+		if t.Ratio() < policy.ratio {
+			below = append(below, t.Name)
+		}
+	}
+	if len(below) > 0 {
+		return false, fmt.Sprintf("below ratio %.1f: %v", policy.ratio, strings.Join(below, ", "))
+	}
+	return true, fmt.Sprintf("all torrents reached ratio %.1f", policy.ratio)
+}
@@ -0,0 +1,244 @@
+package doTorrentDownloader
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+const defaultTransferParallel = 4
+
+// sftpTransferer copies files over SFTP on the SSH connection that is used
+// for everything else, so it needs neither rsync nor OpenSSH locally.
+type sftpTransferer struct {
+	conf     *config
+	conn     *ssh.Client
+	client   *sftp.Client
+	parallel int
+	control  *transferControl
+}
+
+// sftpFile is a regular file to be copied from the droplet.
+type sftpFile struct {
+	job        *jobState
+	remotePath string
+	localPath  string
+	size       int64
+	modTime    time.Time
+}
+
+// jobState tracks the progress of a job while its files are copied by
+// several workers.
+type jobState struct {
+	job              TransferJob
+	bytesDone        int64
+	bytesTotal       int64
+	bytesTransferred int64
+	filesDone        int
+	filesTotal       int
+}
+
+func newSftpTransferer(conf *config, sshClient SshClientOp, control *transferControl) (*sftpTransferer, error) {
+	conn, err := sshClient.Dial()
+	if err != nil {
+		return nil, err
+	}
+	client, err := sftp.NewClient(conn, sftp.UseConcurrentReads(true))
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	parallel := conf.Transfer.Parallel
+	if parallel <= 0 {
+		parallel = defaultTransferParallel
+	}
+	return &sftpTransferer{conf: conf, conn: conn, client: client, parallel: parallel, control: control}, nil
+}
+
+func (transferer *sftpTransferer) Name() string {
+	return "sftp"
+}
+
+func (transferer *sftpTransferer) Transfer(jobs []TransferJob, progress func(TransferProgress)) error {
+	defer transferer.conn.Close()
+	defer transferer.client.Close()
+
+	var files []sftpFile
+	for _, job := range jobs {
+		state := &jobState{job: job}
+		jobFiles, err := transferer.listFiles(state)
+		if err != nil {
+			return fmt.Errorf("error listing %v: %v", job.RemotePath, err)
+		}
+		files = append(files, jobFiles...)
+	}
+
+	var mu sync.Mutex
+	report := func(file sftpFile, done int64, transferred bool, fileDone bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		file.job.bytesDone += done
+		if transferred {
+			file.job.bytesTransferred += done
+		}
+		if fileDone {
+			file.job.filesDone++
+		}
+		if progress != nil {
+			progress(TransferProgress{
+				Job:              file.job.job.Name,
+				File:             file.localPath,
+				BytesDone:        file.job.bytesDone,
+				BytesTotal:       file.job.bytesTotal,
+				BytesTransferred: file.job.bytesTransferred,
+				FilesDone:        file.job.filesDone,
+				FilesTotal:       file.job.filesTotal,
+			})
+		}
+	}
+
+	queue := make(chan sftpFile)
+	errs := make(chan error, len(files))
+	var wg sync.WaitGroup
+	for i := 0; i < transferer.parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for file := range queue {
+				if err := transferer.copyFile(file, report); err != nil {
+					errs <- fmt.Errorf("%v: %v", file.remotePath, err)
+				}
+			}
+		}()
+	}
+	for _, file := range files {
+		queue <- file
+	}
+	close(queue)
+	wg.Wait()
+	close(errs)
+
+	var failed []error
+	for err := range errs {
+		failed = append(failed, err)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d file(s) failed to transfer, first error: %v", len(failed), failed[0])
+	}
+	return nil
+}
+
+// listFiles walks the job's remote path, creates the local directories and
+// returns the regular files to be copied, filtered and placed by the job's
+// rules.
+func (transferer *sftpTransferer) listFiles(state *jobState) ([]sftpFile, error) {
+	job := state.job
+	base := path.Dir(job.RemotePath)
+
+	var files []sftpFile
+	walker := transferer.client.Walk(job.RemotePath)
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			return nil, err
+		}
+		relative := strings.TrimPrefix(walker.Path()[len(base):], "/")
+
+		info := walker.Stat()
+		if info.IsDir() {
+			if !job.Rules.Active() {
+				if err := os.MkdirAll(filepath.Join(job.LocalDir, filepath.FromSlash(relative)), 0755); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+		if !info.Mode().IsRegular() {
+			continue
+		}
+		localPath, ok := job.Rules.Place(job, relative, info.Size())
+		if !ok {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+			return nil, err
+		}
+		files = append(files, sftpFile{
+			job:        state,
+			remotePath: walker.Path(),
+			localPath:  localPath,
+			size:       info.Size(),
+			modTime:    info.ModTime(),
+		})
+		state.bytesTotal += info.Size()
+		state.filesTotal++
+	}
+	return files, nil
+}
+
+// copyFile copies the file unless the local copy has the same size and
+// modification time. A smaller local copy is taken as a partial transfer and
+// resumed from where it stopped.
+func (transferer *sftpTransferer) copyFile(file sftpFile, report func(sftpFile, int64, bool, bool)) error {
+	var offset int64
+	if info, err := os.Stat(file.localPath); err == nil {
+		if info.Size() == file.size && info.ModTime().Unix() == file.modTime.Unix() {
+			report(file, file.size, false, true)
+			return nil
+		}
+		if info.Size() < file.size {
+			offset = info.Size()
+		}
+	}
+
+	remote, err := transferer.client.Open(file.remotePath)
+	if err != nil {
+		return err
+	}
+	defer remote.Close()
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if offset > 0 {
+		flags = os.O_WRONLY | os.O_APPEND
+		if _, err := remote.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+		report(file, offset, false, false)
+	}
+	local, err := os.OpenFile(file.localPath, flags, 0644)
+	if err != nil {
+		return err
+	}
+
+	writer := transferer.control.writer(local, filepath.Dir(file.localPath), true)
+	_, err = remote.WriteTo(&progressWriter{writer: writer, report: func(n int64) { report(file, n, true, false) }})
+	if closeErr := local.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	report(file, 0, false, true)
+	return os.Chtimes(file.localPath, file.modTime, file.modTime)
+}
+
+// progressWriter reports the number of bytes written through it.
+type progressWriter struct {
+	writer io.Writer
+	report func(int64)
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	n, err := w.writer.Write(p)
+	w.report(int64(n))
+	return n, err
+}
@@ -0,0 +1,218 @@
+package doTorrentDownloader
+
+import (
+	"fmt"
+	"io"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+)
+
+// TransferJob copies one torrent's data, a file or a directory, from the
+// droplet into LocalDir. With active Rules the files are filtered and placed
+// by the rules instead.
+type TransferJob struct {
+	Name        string
+	Hash        string
+	Category    string
+	RemotePath  string
+	LocalDir    string
+	Destination string
+	Rules       *transferRules
+	// Extracted is set when the job is the directory extracted from a
+	// torrent that is a single archive, rather than the torrent's data.
+	Extracted bool
+}
+
+// TransferProgress is reported by transferers while a job is running. Bytes
+// are absolute for the job, not deltas. BytesDone includes the data that was
+// already present locally, BytesTransferred only what was actually moved.
+type TransferProgress struct {
+	Job              string
+	File             string
+	BytesDone        int64
+	BytesTotal       int64
+	BytesTransferred int64
+	FilesDone        int
+	FilesTotal       int
+}
+
+// Transferer copies the completed downloads from the droplet to the local
+// machine.
+type Transferer interface {
+	Name() string
+	Transfer(jobs []TransferJob, progress func(TransferProgress)) error
+}
+
+// transferControl paces a transfer with the bandwidth limiter and the disk
+// space guard, both of which are optional.
+type transferControl struct {
+	limiter *bandwidthLimiter
+	guard   *diskGuard
+}
+
+// newTransferControl sets up the limits of the config. hourlyPrice is the
+// droplet's price, used to show what waiting for a bandwidth window costs.
+func newTransferControl(conf *config, hourlyPrice float64) (*transferControl, error) {
+	limiter, err := newBandwidthLimiter(conf, hourlyPrice)
+	if err != nil {
+		return nil, err
+	}
+	guard, err := newDiskGuard(conf)
+	if err != nil {
+		return nil, err
+	}
+	return &transferControl{limiter: limiter, guard: guard}, nil
+}
+
+// SetNotify shows the messages about limits and pauses with notify.
+func (control *transferControl) SetNotify(notify func(string)) {
+	if control == nil {
+		return
+	}
+	if control.limiter != nil {
+		control.limiter.notify = notify
+	}
+	if control.guard != nil {
+		control.guard.notify = notify
+	}
+}
+
+// Wait blocks while the transfer into dir is paused by the bandwidth
+// schedule or for lack of disk space.
+func (control *transferControl) Wait(dir string) {
+	if control == nil {
+		return
+	}
+	if control.limiter != nil {
+		control.limiter.Rate()
+	}
+	if control.guard != nil {
+		control.guard.Check(dir)
+	}
+}
+
+func (control *transferControl) bandwidth() *bandwidthLimiter {
+	if control == nil {
+		return nil
+	}
+	return control.limiter
+}
+
+// mustPause tells whether a transfer into dir that runs at rate must be
+// stopped, as the bandwidth schedule changed or the disk is short of space.
+func (control *transferControl) mustPause(dir string, rate int64) bool {
+	if control == nil {
+		return false
+	}
+	if control.limiter != nil && control.limiter.schedule.RateAt(time.Now()) != rate {
+		return true
+	}
+	if control.guard != nil {
+		if free, _, err := diskUsage(dir); err == nil && free < control.guard.reserve {
+			return true
+		}
+	}
+	return false
+}
+
+// writer wraps the writer of a file in dir so that writes wait for disk
+// space and, when throttle is set, for the bandwidth limit.
+func (control *transferControl) writer(writer io.Writer, dir string, throttle bool) io.Writer {
+	if control == nil {
+		return writer
+	}
+	if throttle && control.limiter != nil {
+		writer = &throttledWriter{writer: writer, limiter: control.limiter}
+	}
+	if control.guard != nil {
+		writer = &guardedWriter{writer: writer, guard: control.guard, dir: dir}
+	}
+	return writer
+}
+
+// reader wraps a reader of transferred data in the bandwidth limit.
+func (control *transferControl) reader(reader io.Reader) io.Reader {
+	if control == nil || control.limiter == nil {
+		return reader
+	}
+	return &throttledReader{reader: reader, limiter: control.limiter}
+}
+
+// NewTransferer returns the transfer engine selected in the config. The
+// built-in SFTP engine is the default and rsync is used when asked for or
+// when the droplet doesn't offer SFTP. Jobs with many small files are packed
+// unless that is turned off.
+func NewTransferer(conf *config, sshClient SshClientOp, ip string, control *transferControl) (Transferer, error) {
+	var transferer Transferer
+	switch conf.Transfer.Engine {
+	case "", "sftp":
+		sftpTransferer, err := newSftpTransferer(conf, sshClient, control)
+		if err == nil {
+			transferer = sftpTransferer
+			break
+		}
+		if _, lookErr := exec.LookPath("rsync"); lookErr != nil {
+			return nil, err
+		}
+		logWarn(fmt.Sprintf("SFTP is not available (%v), falling back to rsync.", err))
+		transferer = newRsyncTransferer(conf, sshClient, ip, control)
+	case "rsync":
+		transferer = newRsyncTransferer(conf, sshClient, ip, control)
+	default:
+		return nil, fmt.Errorf("unknown transfer engine %q, use sftp or rsync", conf.Transfer.Engine)
+	}
+	return newPackTransferer(conf, sshClient, control, transferer)
+}
+
+// transferJobs returns one job per entry in the completed directory on the
+// droplet. Entries are matched to the given torrents by name to know their
+// hashes and categories, also by the directories their archives were
+// extracted into, and destinations are looked up by hash.
+func transferJobs(conf *config, sshClient SshClientOp, torrents []Torrent, rules *transferRules, destinations map[string]string, extractions map[string]extraction) []TransferJob {
+	output := sshClient.executeCmd(fmt.Sprintf("find %s -mindepth 1 -maxdepth 1 -printf '%%f\\n'", shellQuote(conf.Qbit.CompletedDir)))
+
+	var jobs []TransferJob
+	for _, name := range strings.Split(strings.TrimSpace(output), "\n") {
+		if name == "" || strings.HasSuffix(name, ".aria2") {
+			continue
+		}
+		job := TransferJob{
+			Name:       name,
+			RemotePath: path.Join(conf.Qbit.CompletedDir, name),
+			LocalDir:   conf.DownloadDir,
+			Rules:      rules,
+		}
+		for _, t := range torrents {
+			if t.Name == name {
+				job.Hash = t.Hash
+				job.Category = t.Category
+			} else if extracted, ok := extractions[t.Name]; ok && extracted.dir == name {
+				job.Hash = t.Hash
+				job.Category = t.Category
+				job.Extracted = true
+			}
+		}
+		if destination, ok := destinations[strings.ToLower(job.Hash)]; ok && job.Hash != "" {
+			job.Destination = destination
+			job.LocalDir = destination
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs
+}
+
+// formatBytes formats the byte count with a binary unit.
+func formatBytes(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.2f %ciB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
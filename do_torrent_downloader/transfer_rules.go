@@ -0,0 +1,245 @@
+package doTorrentDownloader
+
+import (
+	"encoding/base32"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// destinationRule places the files with one of the extensions, e.g. video
+// files into /media/{name}.
+type destinationRule struct {
+	Extensions []string `yaml:"extensions"`
+	Path       string   `yaml:"path"`
+}
+
+// transferRules decide which files of the completed torrents are transferred
+// and where they go. Paths are templates of where the torrent's top-level
+// file or directory is placed, relative to download_dir unless absolute.
+type transferRules struct {
+	include      []string
+	exclude      []string
+	minSize      int64
+	destination  string
+	destinations []destinationRule
+}
+
+var templatePattern = regexp.MustCompile(`\{[^}]*\}`)
+
+// templateFields are the placeholders of destination templates.
+var templateFields = map[string]bool{"{name}": true, "{category}": true, "{hash}": true}
+
+func newTransferRules(conf *config) (*transferRules, error) {
+	rules := &transferRules{
+		include:      lowerAll(conf.Transfer.Include),
+		exclude:      lowerAll(conf.Transfer.Exclude),
+		destination:  conf.Transfer.Destination,
+		destinations: conf.Transfer.Destinations,
+	}
+	for _, pattern := range append(rules.include, rules.exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid transfer pattern %q: %v", pattern, err)
+		}
+	}
+	if conf.Transfer.MinSize != "" {
+		size, err := parseSize(conf.Transfer.MinSize)
+		if err != nil {
+			return nil, fmt.Errorf("invalid min_size: %v", err)
+		}
+		rules.minSize = size
+	}
+	templates := []string{rules.destination}
+	for _, rule := range rules.destinations {
+		if rule.Path == "" || len(rule.Extensions) == 0 {
+			return nil, fmt.Errorf("destinations need extensions and a path")
+		}
+		templates = append(templates, rule.Path)
+	}
+	for _, template := range templates {
+		for _, field := range templatePattern.FindAllString(template, -1) {
+			if !templateFields[field] {
+				return nil, fmt.Errorf("unknown field %v in destination %q, use {name}, {category} or {hash}", field, template)
+			}
+		}
+	}
+	return rules, nil
+}
+
+func lowerAll(values []string) []string {
+	var lowered []string
+	for _, value := range values {
+		lowered = append(lowered, strings.ToLower(value))
+	}
+	return lowered
+}
+
+// Active tells whether the rules filter or move any files. Without them
+// jobs are transferred as a whole into their LocalDir.
+func (rules *transferRules) Active() bool {
+	return rules != nil && (len(rules.include) > 0 || len(rules.exclude) > 0 || rules.minSize > 0 ||
+		rules.destination != "" || len(rules.destinations) > 0)
+}
+
+// Place returns the local path of a file of the job, or false when the file
+// is filtered out. name is the slash separated path of the file relative to
+// the job's parent directory on the droplet, i.e. it starts with the job's
+// name.
+func (rules *transferRules) Place(job TransferJob, name string, size int64) (string, bool) {
+	if rules == nil {
+		rules = &transferRules{}
+	}
+	lowered := strings.ToLower(name)
+	if len(rules.include) > 0 && !matchesAny(rules.include, lowered) {
+		return "", false
+	}
+	if matchesAny(rules.exclude, lowered) || size < rules.minSize {
+		return "", false
+	}
+
+	return rules.place(job, name), true
+}
+
+// TopPath returns where the transferred job's top-level file or directory
+// is placed, e.g. for hooks. Files placed by extension may end up
+// elsewhere.
+func (rules *transferRules) TopPath(job TransferJob) string {
+	if rules == nil {
+		rules = &transferRules{}
+	}
+	// A single file is placed differently from the files of a directory
+	// when the template has no {name}.
+	if top := rules.place(job, job.Name); isRegularFile(top) {
+		return top
+	}
+	return filepath.Dir(rules.place(job, job.Name+"/_"))
+}
+
+func isRegularFile(name string) bool {
+	info, err := os.Stat(name)
+	return err == nil && info.Mode().IsRegular()
+}
+
+func (rules *transferRules) place(job TransferJob, name string) string {
+	if job.Destination != "" {
+		return filepath.Join(job.Destination, filepath.FromSlash(name))
+	}
+	template := rules.destination
+	extension := strings.TrimPrefix(strings.ToLower(path.Ext(name)), ".")
+	for _, rule := range rules.destinations {
+		for _, candidate := range rule.Extensions {
+			if strings.TrimPrefix(strings.ToLower(candidate), ".") == extension {
+				template = rule.Path
+				break
+			}
+		}
+		if template != rules.destination {
+			break
+		}
+	}
+	if template == "" {
+		return filepath.Join(job.LocalDir, filepath.FromSlash(name))
+	}
+
+	top := filepath.FromSlash(strings.NewReplacer(
+		"{name}", job.Name,
+		"{category}", job.Category,
+		"{hash}", job.Hash,
+	).Replace(template))
+	if !filepath.IsAbs(template) {
+		top = filepath.Join(job.LocalDir, top)
+	}
+	rest := ""
+	if i := strings.Index(name, "/"); i >= 0 {
+		rest = name[i+1:]
+	} else if !strings.Contains(template, "{name}") {
+		// The file of a single file torrent goes into the directory, not
+		// in its place.
+		rest = name
+	}
+	return filepath.Join(top, filepath.FromSlash(rest))
+}
+
+// matchesAny matches the patterns against the whole path and each of its
+// parts, so that e.g. "sample" excludes a Sample directory.
+func matchesAny(patterns []string, name string) bool {
+	parts := append([]string{name}, strings.Split(name, "/")...)
+	for _, pattern := range patterns {
+		for _, part := range parts {
+			if matched, _ := path.Match(pattern, part); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// splitMagnetDestination splits a "-m <magnet>|<dir>" argument into the link
+// and the directory the torrent is transferred to. Magnet links don't
+// contain "|" after their parameters, so only a trailing part without any
+// parameters is taken as the directory.
+func splitMagnetDestination(value string) (string, string) {
+	i := strings.LastIndex(value, "|")
+	if i < 0 || strings.ContainsAny(value[i+1:], "=&") {
+		return value, ""
+	}
+	return strings.TrimSpace(value[:i]), strings.TrimSpace(value[i+1:])
+}
+
+// magnetInfoHash returns the info hash of a magnet link as the lowercase hex
+// used by the engines, or "" when it has none. v2 hashes are truncated to
+// the 40 characters engines identify v2-only torrents with.
+func magnetInfoHash(link string) string {
+	parsed, err := url.Parse(link)
+	if err != nil {
+		return ""
+	}
+	// Hybrid torrents are identified by their v1 hash.
+	for _, xt := range parsed.Query()["xt"] {
+		if hash := strings.TrimPrefix(xt, "urn:btih:"); hash != xt {
+			if len(hash) == 32 {
+				decoded, err := base32.StdEncoding.DecodeString(strings.ToUpper(hash))
+				if err != nil {
+					return ""
+				}
+				return hex.EncodeToString(decoded)
+			}
+			return strings.ToLower(hash)
+		}
+	}
+	for _, xt := range parsed.Query()["xt"] {
+		if strings.HasPrefix(xt, "urn:btmh:1220") && len(xt) >= 13+40 {
+			return strings.ToLower(xt[13 : 13+40])
+		}
+	}
+	return ""
+}
+
+// magnetDestinations splits the -m arguments into the magnet links and the
+// destination directories given for them, by info hash.
+func magnetDestinations(values []string) ([]string, map[string]string, error) {
+	var links []string
+	destinations := map[string]string{}
+	for _, value := range values {
+		link, dir := splitMagnetDestination(value)
+		links = append(links, link)
+		if dir == "" {
+			continue
+		}
+		hash := magnetInfoHash(link)
+		if hash == "" {
+			return nil, nil, fmt.Errorf("can't set a destination for %v, it has no info hash", link)
+		}
+		dir, err := filepath.Abs(dir)
+		if err != nil {
+			return nil, nil, err
+		}
+		destinations[hash] = dir
+	}
+	return links, destinations, nil
+}
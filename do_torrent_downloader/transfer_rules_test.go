@@ -0,0 +1,158 @@
+package doTorrentDownloader
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testTransferRules(t *testing.T, transfer func(conf *config)) *transferRules {
+	t.Helper()
+	conf := defaultConfig()
+	transfer(conf)
+	rules, err := newTransferRules(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rules
+}
+
+func TestTransferRulesFilters(t *testing.T) {
+	rules := testTransferRules(t, func(conf *config) {
+		conf.Transfer.Include = []string{"*.mkv", "*.srt", "Extras"}
+		conf.Transfer.Exclude = []string{"sample", "*.sample.*"}
+		conf.Transfer.MinSize = "1KB"
+	})
+	job := TransferJob{Name: "Show", LocalDir: "/downloads"}
+
+	tests := []struct {
+		name string
+		size int64
+		want bool
+	}{
+		{"Show/ep1.mkv", 1 << 20, true},
+		{"Show/EP2.MKV", 1 << 20, true},
+		{"Show/ep1.en.srt", 2048, true},
+		{"Show/ep1.nfo", 1 << 20, false},
+		{"Show/Sample/ep1.mkv", 1 << 20, false},
+		{"Show/ep1.sample.mkv", 1 << 20, false},
+		{"Show/tiny.srt", 100, false},
+		{"Show/Extras/making-of.mp4", 1 << 20, true},
+	}
+	for _, test := range tests {
+		if _, got := rules.Place(job, test.name, test.size); got != test.want {
+			t.Errorf("Place(%q, %d) transfers %v, want %v", test.name, test.size, got, test.want)
+		}
+	}
+
+	var none *transferRules
+	if localPath, ok := none.Place(job, "Show/ep1.nfo", 1); !ok || localPath != filepath.FromSlash("/downloads/Show/ep1.nfo") {
+		t.Errorf("nil rules place Show/ep1.nfo at %q, %v", localPath, ok)
+	}
+}
+
+func TestTransferRulesPlace(t *testing.T) {
+	show := TransferJob{Name: "Show", Hash: "abc123", Category: "tv", LocalDir: "/downloads"}
+	movie := TransferJob{Name: "movie.mkv", Hash: "def456", LocalDir: "/downloads"}
+
+	tests := []struct {
+		name        string
+		destination string
+		rules       []destinationRule
+		job         TransferJob
+		file        string
+		want        string
+	}{
+		{name: "no template", job: show, file: "Show/a/ep1.mkv", want: "/downloads/Show/a/ep1.mkv"},
+		{name: "no template single file", job: movie, file: "movie.mkv", want: "/downloads/movie.mkv"},
+		{name: "relative template", destination: "{category}/{name}", job: show, file: "Show/ep1.mkv", want: "/downloads/tv/Show/ep1.mkv"},
+		{name: "absolute template", destination: "/srv/{hash}", job: show, file: "Show/ep1.mkv", want: "/srv/abc123/ep1.mkv"},
+		{name: "template with name single file", destination: "/srv/{name}", job: movie, file: "movie.mkv", want: "/srv/movie.mkv"},
+		{name: "template without name", destination: "/srv", job: show, file: "Show/ep1.mkv", want: "/srv/ep1.mkv"},
+		{name: "template without name single file", destination: "/srv", job: movie, file: "movie.mkv", want: "/srv/movie.mkv"},
+		{name: "template without name single file by category", destination: "{category}", job: TransferJob{Name: "movie.mkv", Category: "films", LocalDir: "/downloads"}, file: "movie.mkv", want: "/downloads/films/movie.mkv"},
+		{
+			name:  "rule by extension",
+			rules: []destinationRule{{Extensions: []string{"iso"}, Path: "/isos/{name}"}, {Extensions: []string{".MKV", "mp4"}, Path: "/media/{name}"}},
+			job:   show, file: "Show/ep1.mkv", want: "/media/Show/ep1.mkv",
+		},
+		{
+			name:  "rule without name single file",
+			rules: []destinationRule{{Extensions: []string{"mkv"}, Path: "/media"}},
+			job:   movie, file: "movie.mkv", want: "/media/movie.mkv",
+		},
+		{
+			name:        "no matching rule",
+			destination: "other/{name}",
+			rules:       []destinationRule{{Extensions: []string{"mkv"}, Path: "/media/{name}"}},
+			job:         show, file: "Show/ep1.srt", want: "/downloads/other/Show/ep1.srt",
+		},
+		{
+			name:        "job destination",
+			destination: "/srv",
+			job:         TransferJob{Name: "Show", LocalDir: "/downloads", Destination: "/elsewhere"},
+			file:        "Show/ep1.mkv", want: "/elsewhere/Show/ep1.mkv",
+		},
+	}
+	for _, test := range tests {
+		rules := testTransferRules(t, func(conf *config) {
+			conf.Transfer.Destination = test.destination
+			conf.Transfer.Destinations = test.rules
+		})
+		localPath, ok := rules.Place(test.job, test.file, 1)
+		if !ok || localPath != filepath.FromSlash(test.want) {
+			t.Errorf("%v: placed at %q, %v, want %q", test.name, localPath, ok, filepath.FromSlash(test.want))
+		}
+	}
+}
+
+func TestTransferRulesTopPath(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "flat"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "flat", "movie.mkv"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		destination string
+		job         string
+		want        string
+	}{
+		{"directory with name", "{name}", "Show", "Show"},
+		{"directory without name", "flat", "Show", "flat"},
+		{"single file without name", "flat", "movie.mkv", "flat/movie.mkv"},
+	}
+	for _, test := range tests {
+		rules := testTransferRules(t, func(conf *config) { conf.Transfer.Destination = test.destination })
+		got := rules.TopPath(TransferJob{Name: test.job, LocalDir: dir})
+		if want := filepath.Join(dir, filepath.FromSlash(test.want)); got != want {
+			t.Errorf("%v: TopPath = %q, want %q", test.name, got, want)
+		}
+	}
+}
+
+func TestNewTransferRulesErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		transfer func(conf *config)
+	}{
+		{"invalid pattern", func(conf *config) { conf.Transfer.Exclude = []string{"[a-"} }},
+		{"invalid min size", func(conf *config) { conf.Transfer.MinSize = "big" }},
+		{"unknown field", func(conf *config) { conf.Transfer.Destination = "/srv/{title}" }},
+		{"rule without path", func(conf *config) {
+			conf.Transfer.Destinations = []destinationRule{{Extensions: []string{"mkv"}}}
+		}},
+		{"rule without extensions", func(conf *config) { conf.Transfer.Destinations = []destinationRule{{Path: "/media"}} }},
+	}
+	for _, test := range tests {
+		conf := defaultConfig()
+		test.transfer(conf)
+		if _, err := newTransferRules(conf); err == nil {
+			t.Errorf("%v: newTransferRules didn't fail", test.name)
+		}
+	}
+}
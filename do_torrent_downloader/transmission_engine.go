@@ -0,0 +1,259 @@
+package doTorrentDownloader
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const transmissionPort = 9091
+
+type transmissionEngine struct {
+	conf      *config
+	sshClient SshClientOp
+	sessionID string
+}
+
+// transmissionTorrent is a torrent as returned by Transmission's torrent-get
+// RPC method.
+type transmissionTorrent struct {
+	HashString              string   `json:"hashString"`
+	Name                    string   `json:"name"`
+	PercentDone             float64  `json:"percentDone"`
+	RateDownload            int64    `json:"rateDownload"`
+	RateUpload              int64    `json:"rateUpload"`
+	Eta                     int64    `json:"eta"`
+	Status                  int      `json:"status"`
+	SizeWhenDone            int64    `json:"sizeWhenDone"`
+	HaveValid               int64    `json:"haveValid"`
+	UploadedEver            int64    `json:"uploadedEver"`
+	Error                   int      `json:"error"`
+	MetadataPercentComplete float64  `json:"metadataPercentComplete"`
+	Labels                  []string `json:"labels"`
+	PeersSendingToUs        int      `json:"peersSendingToUs"`
+	PeersGettingFromUs      int      `json:"peersGettingFromUs"`
+}
+
+var transmissionFields = []string{
+	"hashString", "name", "percentDone", "rateDownload", "eta", "status",
+	"sizeWhenDone", "haveValid", "uploadedEver", "error", "metadataPercentComplete", "labels",
+	"rateUpload", "peersSendingToUs", "peersGettingFromUs",
+}
+
+func newTransmissionEngine(conf *config, sshClient SshClientOp) *transmissionEngine {
+	return &transmissionEngine{conf: conf, sshClient: sshClient}
+}
+
+func (engine *transmissionEngine) Name() string {
+	return "Transmission"
+}
+
+func (engine *transmissionEngine) WebUIPort() int {
+	return transmissionPort
+}
+
+func (engine *transmissionEngine) image() string {
+	return engineImage(engine.conf, "linuxserver/transmission:latest")
+}
+
+func (engine *transmissionEngine) Setup(attach bool) error {
+	if attach {
+		logInfo("Checking for a running Transmission to attach to...")
+		if engine.isHealthy() {
+			_, err := engine.List()
+			if err == nil {
+				logInfo("Attached to the running Transmission.")
+				return nil
+			}
+			return attachError(engine.Name(), err)
+		}
+		logInfo("Setting up Transmission again.")
+	}
+
+	conf := engine.conf
+	logInfo(fmt.Sprintf("Creating directories: %s, %s", conf.Qbit.IncomingDir, conf.Qbit.CompletedDir))
+	engine.sshClient.executeCmd(fmt.Sprintf("mkdir -p %s %s /root/config/transmission", conf.Qbit.IncomingDir, conf.Qbit.CompletedDir))
+
+	// The linuxserver image keeps incomplete torrents in /downloads/incomplete
+	// and moves them to /downloads/complete once done.
+	startContainer(engine.sshClient, "transmission", engine.image(), fmt.Sprintf(`\
+		-e PUID=0 \
+		-e PGID=0 \
+		-e TZ=Etc/UTC \
+		-e USER=admin \
+		-e PASS=%s \
+		-p %d:%d \
+		-p 51413:51413 \
+		-p 51413:51413/udp \
+		-v %s:/downloads/incomplete \
+		-v %s:/downloads/complete \
+		-v /root/config/transmission:/config`,
+		shellQuote(conf.QbittorrentPassword),
+		transmissionPort, transmissionPort,
+		conf.Qbit.IncomingDir,
+		conf.Qbit.CompletedDir), "")
+
+	logInfo("Waiting for Transmission to initialize...")
+	waitForPort(engine.sshClient, transmissionPort)
+	_, err := engine.List()
+	return err
+}
+
+func (engine *transmissionEngine) Attach() error {
+	if !engine.isHealthy() {
+		return notRunningError(engine.Name())
+	}
+	if _, err := engine.List(); err != nil {
+		return attachError(engine.Name(), err)
+	}
+	return nil
+}
+
+func (engine *transmissionEngine) isHealthy() bool {
+	return containerHealthy(engine.sshClient, "transmission", engine.image(), []string{
+		fmt.Sprintf("%s:/downloads/incomplete", strings.TrimSuffix(engine.conf.Qbit.IncomingDir, "/")),
+		fmt.Sprintf("%s:/downloads/complete", strings.TrimSuffix(engine.conf.Qbit.CompletedDir, "/")),
+	}, transmissionPort)
+}
+
+// rpc calls the Transmission RPC method and decodes its arguments into
+// result. Transmission answers 409 with a new session ID whenever the one
+// sent is missing or stale, after which the call is retried once.
+func (engine *transmissionEngine) rpc(method string, arguments interface{}, result interface{}) error {
+	body, err := json.Marshal(map[string]interface{}{"method": method, "arguments": arguments})
+	if err != nil {
+		return err
+	}
+
+	for attempt := 0; attempt < 2; attempt++ {
+		cmd := fmt.Sprintf("curl -s -i -u %s -H %s -d %s http://localhost:%d/transmission/rpc",
+			shellQuote("admin:"+engine.conf.QbittorrentPassword),
+			shellQuote("X-Transmission-Session-Id: "+engine.sessionID),
+			shellQuote(string(body)),
+			transmissionPort)
+		status, headers, payload := splitHTTPResponse(engine.sshClient.executeCmd(cmd))
+		if status == 409 {
+			engine.sessionID = headers["x-transmission-session-id"]
+			continue
+		}
+		if status != 200 {
+			return fmt.Errorf("Transmission RPC %s returned status %d", method, status)
+		}
+
+		var response struct {
+			Result    string          `json:"result"`
+			Arguments json.RawMessage `json:"arguments"`
+		}
+		if err := json.Unmarshal([]byte(payload), &response); err != nil {
+			return err
+		}
+		if response.Result != "success" {
+			return fmt.Errorf("Transmission RPC %s failed: %s", method, response.Result)
+		}
+		if result != nil {
+			return json.Unmarshal(response.Arguments, result)
+		}
+		return nil
+	}
+	return fmt.Errorf("Transmission RPC %s kept rejecting the session ID", method)
+}
+
+func (engine *transmissionEngine) Teardown() {
+	logInfo("Stopping and removing transmission container to stop seeding...")
+	removeContainer(engine.sshClient, "transmission")
+	logInfo("Transmission container removed.")
+}
+
+func (engine *transmissionEngine) List() ([]Torrent, error) {
+	var result struct {
+		Torrents []transmissionTorrent `json:"torrents"`
+	}
+	if err := engine.rpc("torrent-get", map[string]interface{}{"fields": transmissionFields}, &result); err != nil {
+		return nil, err
+	}
+
+	torrents := make([]Torrent, 0, len(result.Torrents))
+	for _, t := range result.Torrents {
+		eta := t.Eta
+		if eta < 0 { // -1 is "not available" and -2 "unknown"
+			eta = EtaUnknown
+		}
+		category := ""
+		if len(t.Labels) > 0 {
+			// Transmission has labels instead of categories.
+			category = t.Labels[0]
+		}
+		torrents = append(torrents, Torrent{
+			Hash:       t.HashString,
+			Name:       t.Name,
+			Progress:   t.PercentDone,
+			Dlspeed:    t.RateDownload,
+			Upspeed:    t.RateUpload,
+			Eta:        eta,
+			State:      transmissionState(t),
+			Size:       t.SizeWhenDone,
+			Downloaded: t.HaveValid,
+			Uploaded:   t.UploadedEver,
+			Category:   category,
+			Seeds:      t.PeersSendingToUs,
+			Peers:      t.PeersGettingFromUs,
+		})
+	}
+	return torrents, nil
+}
+
+// transmissionState maps Transmission's status codes to the normalized
+// states.
+func transmissionState(t transmissionTorrent) string {
+	if t.Error != 0 {
+		return StateError
+	}
+	switch t.Status {
+	case 0: // stopped
+		if t.PercentDone >= 1.0 {
+			return StateCompleted
+		}
+		return StatePaused
+	case 1, 2: // check queued, checking
+		return StateChecking
+	case 3: // download queued
+		return StateQueued
+	case 4: // downloading
+		if t.MetadataPercentComplete < 1.0 {
+			return StateMetadata
+		}
+		if t.RateDownload == 0 {
+			return StateStalled
+		}
+		return StateDownloading
+	}
+	// 5 and 6: seed queued, seeding
+	return StateSeeding
+}
+
+func (engine *transmissionEngine) Status(hash string) (Torrent, error) {
+	return findTorrent(engine, hash)
+}
+
+func (engine *transmissionEngine) Add(links []string) error {
+	logInfo("Adding torrents...")
+	for _, link := range links {
+		if err := engine.rpc("torrent-add", map[string]interface{}{"filename": link}, nil); err != nil {
+			return err
+		}
+	}
+	logInfo("Torrents added.")
+	return nil
+}
+
+func (engine *transmissionEngine) Pause(hashes []string) error {
+	return engine.rpc("torrent-stop", map[string]interface{}{"ids": hashes}, nil)
+}
+
+func (engine *transmissionEngine) Resume(hashes []string) error {
+	return engine.rpc("torrent-start", map[string]interface{}{"ids": hashes}, nil)
+}
+
+func (engine *transmissionEngine) Remove(hashes []string, deleteFiles bool) error {
+	return engine.rpc("torrent-remove", map[string]interface{}{"ids": hashes, "delete-local-data": deleteFiles}, nil)
+}
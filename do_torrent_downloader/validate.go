@@ -0,0 +1,312 @@
+package doTorrentDownloader
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/digitalocean/godo"
+	"golang.org/x/crypto/ssh"
+)
+
+// checkConfig validates the settings of the config that don't need the
+// DigitalOcean API.
+func checkConfig(conf *config) error {
+	switch conf.Engine {
+	case "", "qbittorrent", "transmission", "aria2":
+	default:
+		return fmt.Errorf("unknown torrent engine %q, use qbittorrent, transmission or aria2", conf.Engine)
+	}
+	switch conf.Transfer.Engine {
+	case "", "sftp", "rsync":
+	default:
+		return fmt.Errorf("unknown transfer engine %q, use sftp or rsync", conf.Transfer.Engine)
+	}
+	switch conf.Transfer.Pack {
+	case "", "auto", "always", "never":
+	default:
+		return fmt.Errorf("unknown pack mode %q, use auto, always or never", conf.Transfer.Pack)
+	}
+	switch conf.Transfer.PackCompression {
+	case "", "gzip", "none":
+	case "zstd":
+		if _, err := exec.LookPath("zstd"); err != nil && conf.Transfer.Pack != "never" {
+			return fmt.Errorf("pack_compression is zstd but zstd isn't installed locally. Install it or use gzip")
+		}
+	default:
+		return fmt.Errorf("unknown pack compression %q, use zstd, gzip or none", conf.Transfer.PackCompression)
+	}
+	if _, err := newBandwidthSchedule(conf.Transfer.BandwidthLimit, conf.Transfer.Windows); err != nil {
+		return err
+	}
+	if _, err := newDiskGuard(conf); err != nil {
+		return err
+	}
+	switch conf.Transfer.DiskCheck {
+	case "", "abort", "warn", "off":
+	default:
+		return fmt.Errorf("unknown disk_check %q, use abort, warn or off", conf.Transfer.DiskCheck)
+	}
+	switch conf.Extract.Transfer {
+	case "", "extracted", "both":
+	default:
+		return fmt.Errorf("unknown extract transfer %q, use extracted or both", conf.Extract.Transfer)
+	}
+	if _, err := hookTimeout(conf); err != nil {
+		return err
+	}
+	if _, err := newTransferRules(conf); err != nil {
+		return err
+	}
+	if _, err := newSeedingPolicy(conf); err != nil {
+		return err
+	}
+	if _, err := parseLogLevel(conf.Log.Level); err != nil {
+		return err
+	}
+	if conf.Log.Keep < 0 {
+		return fmt.Errorf("invalid log keep %d, use 0 to keep all logs", conf.Log.Keep)
+	}
+	if _, err := newNotifier(conf); err != nil {
+		return err
+	}
+	return validateQbitPreferences(conf.Qbit.Preferences)
+}
+
+// preflightCheck is the outcome of one check of the preflight.
+type preflightCheck struct {
+	name    string
+	detail  string
+	err     error
+	warning bool
+}
+
+// preflight checks that a droplet can be created and reached with the
+// config before anything is spent: the token, size, region, image and SSH
+// key against the account, and the local private key, download directory
+// and rsync.
+func preflight(conf *config) []preflightCheck {
+	var checks []preflightCheck
+	add := func(name string, detail string, err error) {
+		checks = append(checks, preflightCheck{name: name, detail: detail, err: err})
+	}
+	warn := func(name string, detail string) {
+		checks = append(checks, preflightCheck{name: name, detail: detail, warning: true})
+	}
+
+	ctx := context.TODO()
+	account, _, err := DoClient.Account.Get(ctx)
+	if err != nil {
+		add("token", "", fmt.Errorf("digital_ocean_pat isn't accepted (%v). Create a token with read and write scopes at https://cloud.digitalocean.com/account/api/tokens", redact(err.Error())))
+		// Nothing else of the account can be checked without it.
+		return append(checks, preflightLocal(conf, "")...)
+	}
+	switch {
+	case account.Status != "active":
+		add("token", "", fmt.Errorf("the account of %v is %v: %v", account.Email, account.Status, account.StatusMessage))
+	case account.DropletLimit > 0:
+		_, resp, err := DoClient.Droplets.List(ctx, &godo.ListOptions{PerPage: 1})
+		if err == nil && resp.Meta != nil && resp.Meta.Total >= account.DropletLimit {
+			add("token", "", fmt.Errorf("the account of %v has reached its limit of %d droplets. Delete a droplet or ask DigitalOcean for a higher limit", account.Email, account.DropletLimit))
+		} else {
+			add("token", "account "+account.Email, nil)
+		}
+	default:
+		add("token", "account "+account.Email, nil)
+	}
+
+	sizes, _, err := DoClient.Sizes.List(ctx, &godo.ListOptions{PerPage: 200})
+	var size *godo.Size
+	for i := range sizes {
+		if sizes[i].Slug == conf.Size {
+			size = &sizes[i]
+		}
+	}
+	switch {
+	case err != nil:
+		add("size", "", fmt.Errorf("error listing the sizes: %v", err))
+	case size == nil:
+		add("size", "", fmt.Errorf("size %q doesn't exist. Set size or -size to one of the slugs of `doctl compute size list`, e.g. s-1vcpu-1gb", conf.Size))
+	case !size.Available:
+		add("size", "", fmt.Errorf("size %q isn't available at the moment, choose another one", conf.Size))
+	default:
+		add("size", fmt.Sprintf("%v, $%.3f/hour", conf.Size, size.PriceHourly), nil)
+	}
+
+	regions, _, err := DoClient.Regions.List(ctx, &godo.ListOptions{PerPage: 100})
+	var region *godo.Region
+	var available []string
+	for i := range regions {
+		if regions[i].Slug == conf.Region {
+			region = &regions[i]
+		}
+		if regions[i].Available {
+			available = append(available, regions[i].Slug)
+		}
+	}
+	sort.Strings(available)
+	switch {
+	case err != nil:
+		add("region", "", fmt.Errorf("error listing the regions: %v", err))
+	case region == nil:
+		add("region", "", fmt.Errorf("region %q doesn't exist, use one of %v", conf.Region, strings.Join(available, ", ")))
+	case !region.Available:
+		add("region", "", fmt.Errorf("region %v doesn't take new droplets, use one of %v", conf.Region, strings.Join(available, ", ")))
+	case size != nil && !contains(region.Sizes, conf.Size):
+		add("region", "", fmt.Errorf("size %v isn't available in %v, use a region of %v", conf.Size, conf.Region, strings.Join(size.Regions, ", ")))
+	default:
+		add("region", region.Name, nil)
+	}
+
+	image, _, err := DoClient.Images.GetBySlug(ctx, conf.ImageSlug)
+	switch {
+	case err != nil:
+		add("image", "", fmt.Errorf("image %q isn't found (%v). Set image_slug to a slug of `doctl compute image list-distribution` or `list-application`, e.g. docker-20-04", conf.ImageSlug, err))
+	case region != nil && len(image.Regions) > 0 && !contains(image.Regions, conf.Region):
+		add("image", "", fmt.Errorf("image %v isn't available in %v, use a region of %v", conf.ImageSlug, conf.Region, strings.Join(image.Regions, ", ")))
+	default:
+		add("image", image.Name, nil)
+	}
+
+	key, err := FindKey(conf.SshKey)
+	fingerprint := ""
+	if err != nil {
+		add("ssh key", "", err)
+	} else {
+		add("ssh key", fmt.Sprintf("%v (%v)", key.Name, key.Fingerprint), nil)
+		fingerprint = key.Fingerprint
+	}
+
+	checks = append(checks, preflightLocal(conf, fingerprint)...)
+	if conf.Transfer.Engine != "rsync" {
+		if _, err := exec.LookPath("rsync"); err != nil {
+			warn("rsync", "not installed, there is no fallback when the droplet's SFTP server fails")
+		}
+	}
+	return checks
+}
+
+// preflightLocal checks the private key, which has to match the public key
+// of the fingerprint if given, rsync when it is the transfer engine, and
+// the download directory.
+func preflightLocal(conf *config, fingerprint string) []preflightCheck {
+	var checks []preflightCheck
+	add := func(name string, detail string, err error) {
+		checks = append(checks, preflightCheck{name: name, detail: detail, err: err})
+	}
+
+	keyPath := conf.SshPrivateKeyPath
+	agent := os.Getenv("SSH_AUTH_SOCK") != ""
+	buffer, err := ioutil.ReadFile(keyPath)
+	switch {
+	case keyPath == "" && agent:
+		add("private key", "using ssh-agent", nil)
+	case keyPath == "":
+		add("private key", "", fmt.Errorf("set ssh_private_key_path or start an ssh-agent with the key"))
+	case err != nil && agent:
+		checks = append(checks, preflightCheck{name: "private key", detail: fmt.Sprintf("%v isn't readable, using ssh-agent", keyPath), warning: true})
+	case err != nil:
+		add("private key", "", fmt.Errorf("ssh_private_key_path %v isn't readable: %v", keyPath, err))
+	default:
+		signer, err := ssh.ParsePrivateKey(buffer)
+		if _, ok := err.(*ssh.PassphraseMissingError); ok {
+			add("private key", keyPath+", protected by a passphrase", nil)
+		} else if err != nil {
+			add("private key", "", fmt.Errorf("%v isn't a private key: %v", keyPath, err))
+		} else if fingerprint != "" && ssh.FingerprintLegacyMD5(signer.PublicKey()) != fingerprint && agent {
+			checks = append(checks, preflightCheck{name: "private key", detail: fmt.Sprintf("%v doesn't belong to ssh_key %q, relying on ssh-agent", keyPath, conf.SshKey), warning: true})
+		} else if fingerprint != "" && ssh.FingerprintLegacyMD5(signer.PublicKey()) != fingerprint {
+			add("private key", "", fmt.Errorf("%v doesn't belong to ssh_key %q. Set ssh_private_key_path to its private key or ssh_key to the name of this key's public key in DigitalOcean", keyPath, conf.SshKey))
+		} else {
+			add("private key", keyPath, nil)
+		}
+	}
+
+	if conf.Transfer.Engine == "rsync" {
+		if _, err := exec.LookPath("rsync"); err != nil {
+			add("rsync", "", fmt.Errorf("transfer.engine is rsync but rsync isn't installed. Install it or use the sftp engine"))
+		} else {
+			add("rsync", "installed", nil)
+		}
+	}
+
+	if conf.DownloadDir == "" {
+		add("download dir", "", fmt.Errorf("set download_dir or -dir to where the downloads are copied to"))
+	} else if info, err := os.Stat(conf.DownloadDir); err == nil && !info.IsDir() {
+		add("download dir", "", fmt.Errorf("download_dir %v isn't a directory", conf.DownloadDir))
+	} else if err != nil {
+		checks = append(checks, preflightCheck{name: "download dir", detail: conf.DownloadDir + " will be created", warning: true})
+	} else {
+		add("download dir", conf.DownloadDir, nil)
+	}
+	return checks
+}
+
+func contains(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
+
+// printPreflight prints the checks and returns whether all passed.
+func printPreflight(checks []preflightCheck) bool {
+	passed := true
+	for _, check := range checks {
+		switch {
+		case check.err != nil:
+			passed = false
+			fmt.Printf("[error] %v: %v\n", check.name, check.err)
+			logToFile(levelError, "Preflight check failed", "check", check.name, "error", check.err)
+		case check.warning:
+			fmt.Printf("[warning] %v: %v\n", check.name, check.detail)
+			logToFile(levelWarn, "Preflight check warning", "check", check.name, "detail", check.detail)
+		default:
+			fmt.Printf("[ok] %v: %v\n", check.name, check.detail)
+			logToFile(levelInfo, "Preflight check passed", "check", check.name, "detail", check.detail)
+		}
+	}
+	return passed
+}
+
+// RunValidateCommand checks the configuration and the account without
+// creating anything.
+func RunValidateCommand(args []string) int {
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	configPath := flags.String("config", "", "Path of the configuration file (.yml, .yaml or .json)")
+	profile := flags.String("profile", "", "Name of the configuration profile to use")
+	size := flags.String("size", "", "Size slug of the droplet (overrides what is set in the config file)")
+	dir := flags.String("dir", "", "Download to directory (overrides what is set in the config file)")
+	flags.Parse(args)
+
+	conf, err := LoadConfiguration(*configPath, *profile)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	if *size != "" {
+		conf.Size = *size
+	}
+	if *dir != "" {
+		conf.DownloadDir = *dir
+	}
+	if err := checkConfig(conf); err != nil {
+		fmt.Printf("[error] config: %v\n", err)
+		return 1
+	}
+	fmt.Println("[ok] config")
+
+	InitDoClient(conf.DigitalOceanPat)
+	if !printPreflight(preflight(conf)) {
+		return 1
+	}
+	fmt.Println("The configuration is valid.")
+	return 0
+}
@@ -0,0 +1,203 @@
+package doTorrentDownloader
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const maxRepairAttempts = 2
+
+// manifest maps the paths of a job's files, relative to the directory the
+// job copies into and slash separated, to their SHA-256 sums.
+type manifest map[string]string
+
+// remoteManifest hashes the job's files on the droplet.
+func remoteManifest(sshClient SshClientOp, job TransferJob) (manifest, error) {
+	output := sshClient.executeCmd(fmt.Sprintf(
+		"cd %s && find %s -type f -print0 | xargs -0 -r sha256sum",
+		shellQuote(path.Dir(job.RemotePath)), shellQuote(path.Base(job.RemotePath)),
+	))
+
+	files := manifest{}
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		line := scanner.Text()
+		// sha256sum prefixes the line with a backslash and escapes the name
+		// when it contains a backslash or a newline.
+		escaped := strings.HasPrefix(line, "\\")
+		line = strings.TrimPrefix(line, "\\")
+		parts := strings.SplitN(line, "  ", 2)
+		if len(parts) != 2 || len(parts[0]) != sha256.Size*2 {
+			continue
+		}
+		name := parts[1]
+		if escaped {
+			name = strings.NewReplacer("\\\\", "\\", "\\n", "\n").Replace(name)
+		}
+		files[name] = parts[0]
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no files found to hash in %v", job.RemotePath)
+	}
+	return files, nil
+}
+
+func hashFile(filePath string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// placeManifest drops the files the job's rules filter out from the manifest
+// and returns the local paths of the others.
+func placeManifest(sshClient SshClientOp, job TransferJob, files manifest) (map[string]string, error) {
+	sizes := map[string]int64{}
+	if job.Rules.Active() {
+		remoteFiles, err := listRemoteFiles(sshClient, job)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range remoteFiles {
+			sizes[file.name] = file.size
+		}
+	}
+
+	localPaths := map[string]string{}
+	for name := range files {
+		localPath, ok := job.Rules.Place(job, name, sizes[name])
+		if !ok {
+			delete(files, name)
+			continue
+		}
+		localPaths[name] = localPath
+	}
+	return localPaths, nil
+}
+
+// verifyLocal hashes the local copies of the manifest's files and returns
+// the ones that are missing or differ, sorted.
+func verifyLocal(localPaths map[string]string, files manifest, parallel int) []string {
+	names := make(chan string)
+	var mu sync.Mutex
+	var mismatches []string
+
+	var wg sync.WaitGroup
+	for i := 0; i < parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range names {
+				sum, err := hashFile(localPaths[name])
+				if err != nil || sum != files[name] {
+					mu.Lock()
+					mismatches = append(mismatches, name)
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for name := range files {
+		names <- name
+	}
+	close(names)
+	wg.Wait()
+
+	sort.Strings(mismatches)
+	return mismatches
+}
+
+// verifyTransfer checks the local copies of the jobs against manifests
+// computed on the droplet and transfers files that don't match again. It
+// returns an error when files still don't match after the last attempt, in
+// which case the droplet must be kept.
+func verifyTransfer(conf *config, sshClient SshClientOp, ip string, jobs []TransferJob, control *transferControl) error {
+	parallel := conf.Transfer.Parallel
+	if parallel <= 0 {
+		parallel = defaultTransferParallel
+	}
+
+	var failed []string
+	for _, job := range jobs {
+		logInfo(fmt.Sprintf("Verifying %v...", job.Name))
+		files, err := remoteManifest(sshClient, job)
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%v (%v)", job.Name, err))
+			continue
+		}
+
+		localPaths, err := placeManifest(sshClient, job, files)
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%v (%v)", job.Name, err))
+			continue
+		}
+
+		mismatches := verifyLocal(localPaths, files, parallel)
+		for attempt := 1; len(mismatches) > 0 && attempt <= maxRepairAttempts; attempt++ {
+			logWarn(fmt.Sprintf("%d file(s) of %v don't match, transferring them again (attempt %d/%d)...",
+				len(mismatches), job.Name, attempt, maxRepairAttempts))
+			if err := repairFiles(conf, sshClient, ip, job, mismatches, localPaths, control); err != nil {
+				logError("Error transferring files again", "error", err)
+			}
+
+			repaired := manifest{}
+			for _, name := range mismatches {
+				repaired[name] = files[name]
+			}
+			mismatches = verifyLocal(localPaths, repaired, parallel)
+		}
+
+		if len(mismatches) > 0 {
+			for _, name := range mismatches {
+				logWarn("Mismatch", "job", job.Name, "file", name)
+			}
+			failed = append(failed, fmt.Sprintf("%v (%d file(s) don't match)", job.Name, len(mismatches)))
+			continue
+		}
+		logInfo(fmt.Sprintf("Verified %d file(s) of %v.", len(files), job.Name))
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("verification failed for %v", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// repairFiles removes the local copies of the files and transfers each of
+// them again as a job of its own.
+func repairFiles(conf *config, sshClient SshClientOp, ip string, job TransferJob, names []string, localPaths map[string]string, control *transferControl) error {
+	var jobs []TransferJob
+	for _, name := range names {
+		localPath := localPaths[name]
+		// Remove the bad copy so it is neither skipped nor resumed from.
+		os.Remove(localPath)
+		jobs = append(jobs, TransferJob{
+			Name:       name,
+			Hash:       job.Hash,
+			RemotePath: path.Join(path.Dir(job.RemotePath), name),
+			LocalDir:   filepath.Dir(localPath),
+		})
+	}
+
+	transferer, err := NewTransferer(conf, sshClient, ip, control)
+	if err != nil {
+		return err
+	}
+	return transferer.Transfer(jobs, nil)
+}
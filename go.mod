@@ -4,14 +4,17 @@ go 1.19
 
 require (
 	github.com/digitalocean/godo v1.87.0
+	github.com/pkg/sftp v1.13.5
 	golang.org/x/crypto v0.1.0
 	golang.org/x/oauth2 v0.1.0
+	golang.org/x/term v0.1.0
 	gopkg.in/yaml.v2 v2.4.0
 )
 
 require (
 	github.com/golang/protobuf v1.5.2 // indirect
 	github.com/google/go-querystring v1.1.0 // indirect
+	github.com/kr/fs v0.1.0 // indirect
 	golang.org/x/net v0.1.0 // indirect
 	golang.org/x/sys v0.1.0 // indirect
 	golang.org/x/time v0.0.0-20220922220347-f3bd1da661af // indirect